@@ -0,0 +1,28 @@
+package hohin
+
+import "github.com/shopspring/decimal"
+
+// Aggregation describes aggregate values that must be computed
+// over a field of entities matching a filter.
+type Aggregation struct {
+	Field   string   // name of an entity field to aggregate
+	Filter  Filter   // filter to select entities
+	GroupBy []string // names of entity fields to group entities by
+}
+
+// Aggregates contains values computed over a group of entities.
+// Sum, Avg, Min and Max are zero when the group is empty.
+type Aggregates struct {
+	Count uint64          // number of entities
+	Sum   decimal.Decimal // sum of field values
+	Avg   decimal.Decimal // average of field values
+	Min   decimal.Decimal // minimum of field values
+	Max   decimal.Decimal // maximum of field values
+}
+
+// Group contains aggregates computed over entities
+// with the same values of fields used for grouping.
+type Group struct {
+	Key []any // values of fields used for grouping in the same order as in [Aggregation.GroupBy]
+	Aggregates
+}
@@ -0,0 +1,20 @@
+package hohin
+
+import "context"
+
+type txKey struct {
+	root DB
+}
+
+// ContextWithTx returns a copy of a context that stores a transaction started with a root DB.
+// It's intended to be used by implementations of [DB] that support ambient transactions,
+// so that repository methods called with the root DB and the context join the transaction.
+func ContextWithTx(ctx context.Context, root DB, tx DB) context.Context {
+	return context.WithValue(ctx, txKey{root}, tx)
+}
+
+// TxFromContext returns a transaction started with a root DB and stored in a context.
+func TxFromContext(ctx context.Context, root DB) (DB, bool) {
+	tx, ok := ctx.Value(txKey{root}).(DB)
+	return tx, ok
+}
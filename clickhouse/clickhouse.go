@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/meowmeowcode/hohin"
+	"github.com/meowmeowcode/hohin/fields"
+	"github.com/meowmeowcode/hohin/keyset"
 	"github.com/meowmeowcode/hohin/maps"
 	"github.com/meowmeowcode/hohin/operations"
 	"github.com/meowmeowcode/hohin/sqldb"
@@ -17,15 +19,55 @@ import (
 
 // DB implements hohin.DB for ClickHouse.
 type DB struct {
-	conn driver.Conn
+	conn  driver.Conn
+	hooks *hohin.Hooks // hooks of a transaction the DB is bound to
 }
 
+// Transaction executes a given function without a transaction
+// because ClickHouse doesn't support them.
+// Changes made by the function are not rolled back if it returns an error,
+// and nested calls behave the same way.
+// Functions registered with AfterCommit and AfterRollback are executed
+// depending on whether the function succeeds.
 func (db *DB) Transaction(ctx context.Context, f func(context.Context, hohin.DB) error) error {
-	return f(ctx, db)
+	return db.TxWithOptions(ctx, hohin.TxOptions{}, f)
 }
 
+// Tx is the same as Transaction, the isolation level is ignored.
 func (db *DB) Tx(ctx context.Context, _ hohin.IsolationLevel, f func(context.Context, hohin.DB) error) error {
-	return f(ctx, db)
+	return db.Transaction(ctx, f)
+}
+
+// TxWithOptions is the same as Transaction, only the timeout option is applied
+// and it cancels a context passed to the function.
+func (db *DB) TxWithOptions(ctx context.Context, opts hohin.TxOptions, f func(context.Context, hohin.DB) error) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	hooks := &hohin.Hooks{}
+	err, panicked := hohin.CallTx(func() error {
+		return f(ctx, &DB{conn: db.conn, hooks: hooks})
+	})
+	hooks.Finish(db.hooks, err == nil)
+	return hohin.Repanic(err, panicked, opts.RecoverPanic)
+}
+
+// TxRetry is the same as Transaction, the isolation level and the retry policy are ignored.
+func (db *DB) TxRetry(ctx context.Context, _ hohin.IsolationLevel, _ hohin.RetryPolicy, f func(context.Context, hohin.DB) error) error {
+	return db.Transaction(ctx, f)
+}
+
+// AfterCommit registers a function executed after a transaction the DB is bound to is committed.
+// The function is executed immediately if the DB isn't bound to a transaction.
+func (db *DB) AfterCommit(f func()) {
+	db.hooks.AfterCommit(f)
+}
+
+// AfterRollback registers a function executed after a transaction the DB is bound to is rolled back.
+func (db *DB) AfterRollback(f func()) {
+	db.hooks.AfterRollback(f)
 }
 
 func (db *DB) Simple() hohin.SimpleDB {
@@ -54,12 +96,14 @@ type Repo[T any] struct {
 	load            func(Scanner) (T, error)
 	afterAdd        func(T) []*sqldb.SQL
 	afterUpdate     func(T) []*sqldb.SQL
+	key             string
+	readOnly        map[string]bool
 }
 
 // Conf contains configuration of a [Repo].
 type Conf[T any] struct {
 	Table   string            // name of a database table
-	Mapping map[string]string // mapping of entity fields to table columns
+	Mapping map[string]string // mapping of entity fields to table columns, built from struct tags by default
 	Query   string            // SQL query to select records from the database
 	// function that transforms an entity to a map where keys are
 	// column names of a database table and values are data for a row in that table
@@ -70,9 +114,14 @@ type Conf[T any] struct {
 	AfterAdd func(T) []*sqldb.SQL
 	// function that builds and returns a sequence of SQL queries to execute after a call of [Repo.Update]
 	AfterUpdate func(T) []*sqldb.SQL
+	// field used to order entities with equal values of other fields in [Repo.GetPage],
+	// a field with the "pk" tag option or "Id" if the mapping contains it by default
+	Key string
 }
 
 // NewRepo creates a [Repo].
+// ClickHouse doesn't support optimistic locking,
+// so it panics if an entity has a field with the "version" tag option.
 func NewRepo[T any](conf Conf[T]) *Repo[T] {
 	if conf.Table == "" {
 		panic("table name is required to create a repository")
@@ -80,23 +129,22 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 
 	r := &Repo[T]{table: conf.Table}
 
+	entityFields := fields.Of(reflect.TypeOf((*T)(nil)).Elem())
 	if conf.Mapping != nil {
 		r.mapping = conf.Mapping
 	} else {
-		var entity T
-		v := reflect.ValueOf(entity)
-		t := v.Type()
-		r.mapping = make(map[string]string)
-		for i := 0; i < v.NumField(); i++ {
-			field := t.Field(i).Name
-			r.mapping[field] = field
-		}
+		r.mapping = fields.Mapping(entityFields)
+	}
+	r.readOnly = fields.ReadOnly(entityFields)
+	if version := fields.Version(entityFields); version != "" {
+		panic(fmt.Sprintf("version field `%s` isn't supported by ClickHouse", version))
 	}
 
 	r.fields, r.columns = maps.Split(r.mapping)
 
 	if conf.Query != "" {
 		r.query = conf.Query
+		r.queryCustomized = true
 	} else {
 		r.query = NewSQL("SELECT ").Join(", ", r.columns...).Add(" FROM ", r.table).String()
 	}
@@ -108,8 +156,11 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 			v := reflect.ValueOf(entity)
 			data := make(map[string]any)
 			for i, field := range r.fields {
+				if r.readOnly[field] {
+					continue
+				}
 				col := r.columns[i]
-				data[col] = v.FieldByName(field).Interface()
+				data[col] = fields.ByName(v, field).Interface()
 			}
 			return data, nil
 		}
@@ -121,18 +172,27 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 		r.load = func(row Scanner) (T, error) {
 			var entity T
 			a := reflect.ValueOf(&entity)
-			fields := make([]any, 0, len(r.fields))
+			dest := make([]any, 0, len(r.fields))
 			for _, field := range r.fields {
-				addr := a.Elem().FieldByName(field).Addr().Interface()
-				fields = append(fields, addr)
+				addr := fields.ByName(a.Elem(), field).Addr().Interface()
+				dest = append(dest, addr)
 			}
-			err := row.Scan(fields...)
+			err := row.Scan(dest...)
 			return entity, err
 		}
 	}
 
 	r.afterAdd = conf.AfterAdd
 	r.afterUpdate = conf.AfterUpdate
+
+	if conf.Key != "" {
+		r.key = conf.Key
+	} else if key := fields.Key(entityFields); key != "" {
+		r.key = key
+	} else if _, ok := r.mapping["Id"]; ok {
+		r.key = "Id"
+	}
+
 	return r
 }
 
@@ -157,16 +217,37 @@ func (r *Repo[T]) Get(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error
 		return zero, hohin.NotFound
 	}
 	if err != nil {
-		return zero, fmt.Errorf("%w while executing query `%s`", err, query)
+		return zero, queryError(query, err)
 	}
 	return entity, nil
 }
 
+// GetOne finds an entity and returns it like Get
+// but returns hohin.ErrMultipleFound if several entities match the filter.
+func (r *Repo[T]) GetOne(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
+	var zero T
+	entities, err := r.GetMany(ctx, d, hohin.Query{Filter: f, Limit: 2})
+	if err != nil {
+		return zero, err
+	}
+	switch len(entities) {
+	case 0:
+		return zero, hohin.NotFound
+	case 1:
+		return entities[0], nil
+	default:
+		return zero, hohin.ErrMultipleFound
+	}
+}
+
 func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 	col, ok := r.mapping[f.Field]
 	if len(f.Field) > 0 && !ok {
 		return fmt.Errorf("unknown field `%s` in a filter", f.Field)
 	}
+	if ref, ok := f.Value.(hohin.FieldRef); ok {
+		return r.compareFields(s, col, f.Operation, ref)
+	}
 	switch f.Operation {
 	case operations.Not:
 		s.Add("NOT (")
@@ -195,6 +276,8 @@ func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 		s.RemoveLast()
 	case operations.IsNull:
 		s.Add(col, " IS NULL")
+	case operations.IsNotNull:
+		s.Add(col, " IS NOT NULL")
 	case operations.Eq:
 		s.Add(col, " = ").Param(f.Value)
 	case operations.IEq:
@@ -211,37 +294,111 @@ func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 		s.Add(col, " <= ").Param(f.Value)
 	case operations.Gte:
 		s.Add(col, " >= ").Param(f.Value)
-	case operations.In:
-		switch val := f.Value.(type) {
-		case []any:
-			s.Add(col, " IN (").JoinParams(", ", val...).Add(")")
+	case operations.Between:
+		bounds := f.Value.([]any)
+		s.Add(col, " BETWEEN ").Param(bounds[0]).Add(" AND ").Param(bounds[1])
+	case operations.In, operations.NotIn:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		not := f.Operation == operations.NotIn
+		switch {
+		case len(items) == 0 && not:
+			s.Add("1 = 1")
+		case len(items) == 0:
+			s.Add("1 = 0")
+		case not:
+			s.Add(col, " NOT IN (").JoinParams(", ", items...).Add(")")
 		default:
-			return fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+			s.Add(col, " IN (").JoinParams(", ", items...).Add(")")
 		}
 	case operations.Contains:
-		s.Add(col, " LIKE '%' || ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " LIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%' ")
 	case operations.IContains:
-		s.Add(col, " ILIKE '%' || ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " ILIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%' ")
 	case operations.HasPrefix:
-		s.Add(col, " LIKE ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " LIKE ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%' ")
 	case operations.IHasPrefix:
-		s.Add(col, " ILIKE ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " ILIKE ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%' ")
 	case operations.HasSuffix:
-		s.Add(col, " LIKE '%' || ").Param(f.Value)
+		s.Add(col, " LIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string)))
 	case operations.IHasSuffix:
-		s.Add(col, " ILIKE '%' || ").Param(f.Value)
+		s.Add(col, " ILIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string)))
 	case operations.IPWithin:
 		s.Add("isIPAddressInRange(toString(", col, "), ").Param(f.Value).Add(")")
+	case operations.Like:
+		s.Add(col, " LIKE ").Param(f.Value)
+	case operations.ILike:
+		s.Add(col, " ILIKE ").Param(f.Value)
+	case operations.Matches:
+		if err := hohin.CheckPattern(f.Value.(string)); err != nil {
+			return err
+		}
+		s.Add("match(", col, ", ").Param(f.Value).Add(")")
+	case operations.IMatches:
+		if err := hohin.CheckPattern(f.Value.(string)); err != nil {
+			return err
+		}
+		s.Add("match(", col, ", concat('(?i)', ").Param(f.Value).Add("))")
+	case operations.HasElement:
+		s.Add("has(", col, ", ").Param(f.Value).Add(")")
+	case operations.HasAny:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		s.Add("hasAny(", col, ", ").Param(items).Add(")")
+	case operations.HasAll:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		s.Add("hasAll(", col, ", ").Param(items).Add(")")
+	case operations.ArrayLen:
+		s.Add("length(", col, ") = ").Param(f.Value)
 	default:
 		return fmt.Errorf("operation %s is not supported", f.Operation)
 	}
 	return nil
 }
 
+// compareFields adds a comparison of a column with a column of another field of an entity.
+func (r *Repo[T]) compareFields(s *sqldb.SQL, col string, op operations.Operation, ref hohin.FieldRef) error {
+	other, ok := r.mapping[string(ref)]
+	if !ok {
+		return fmt.Errorf("unknown field `%s` in a filter", ref)
+	}
+	switch op {
+	case operations.Eq, operations.Ne, operations.Lt, operations.Gt, operations.Lte, operations.Gte:
+		s.Add(col, " ", string(op), " ", other)
+	case operations.IEq:
+		s.Add("UPPER(", col, ") = UPPER(", other, ")")
+	case operations.INe:
+		s.Add("UPPER(", col, ") != UPPER(", other, ")")
+	default:
+		return fmt.Errorf("operation %s is not supported for %T", op, ref)
+	}
+	return nil
+}
+
+// GetForUpdate finds an entity like Get without locking it because ClickHouse cannot lock rows.
+// It's kept for code written for several databases,
+// use GetLocked to get hohin.ErrUnsupported instead of ignoring the lock.
 func (r *Repo[T]) GetForUpdate(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
 	return r.Get(ctx, d, f)
 }
 
+// GetLocked finds an entity like Get if no lock is requested,
+// otherwise it returns hohin.ErrUnsupported because ClickHouse cannot lock rows.
+func (r *Repo[T]) GetLocked(ctx context.Context, d hohin.DB, f hohin.Filter, l hohin.Lock) (T, error) {
+	if l.Strength != hohin.NoLock {
+		var zero T
+		return zero, fmt.Errorf("%w: ClickHouse cannot lock rows", hohin.ErrUnsupported)
+	}
+	return r.Get(ctx, d, f)
+}
+
 func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool, error) {
 	var result bool
 	db := d.(*DB)
@@ -255,24 +412,36 @@ func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool,
 	row := db.conn.QueryRow(ctx, query, params...)
 	err = row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
 func (r *Repo[T]) Delete(ctx context.Context, d hohin.DB, f hohin.Filter) error {
+	_, err := r.DeleteCount(ctx, d, f)
+	return err
+}
+
+// DeleteCount removes entities matching a given filter.
+// The number of removed entities is counted before the deletion is executed.
+func (r *Repo[T]) DeleteCount(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64, error) {
 	db := d.(*DB)
 	sql := NewSQL("DELETE FROM ", r.table, " WHERE ")
-	err := r.applyFilter(sql, f)
+	if err := r.applyFilter(sql, f); err != nil {
+		return 0, err
+	}
+	count, err := r.Count(ctx, d, f)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
 	}
 	query, params := sql.Build()
-	err = db.conn.Exec(ctx, query, params...)
-	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+	if err := db.conn.Exec(ctx, query, params...); err != nil {
+		return 0, queryError(query, err)
 	}
-	return err
+	return count, nil
 }
 
 func (r *Repo[T]) Add(ctx context.Context, d hohin.DB, entity T) error {
@@ -285,13 +454,13 @@ func (r *Repo[T]) Add(ctx context.Context, d hohin.DB, entity T) error {
 	query, params := r.buildInsertQuery(columns, values)
 	err = db.conn.Exec(ctx, query, params...)
 	if err != nil {
-		return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		return queryError(query, err)
 	}
 	if r.afterAdd != nil {
 		for _, sql := range r.afterAdd(entity) {
 			query, params := sql.Build()
 			if err := db.conn.Exec(ctx, query, params...); err != nil {
-				return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+				return queryError(query, err)
 			}
 		}
 	}
@@ -324,7 +493,7 @@ func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 	query, _ := r.buildInsertQuery(columns, values)
 	batch, err := db.conn.PrepareBatch(ctx, query)
 	if err != nil {
-		return err
+		return queryError(query, err)
 	}
 	for _, d := range data {
 		values := make([]any, 0, len(d))
@@ -336,24 +505,72 @@ func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 			return err
 		}
 	}
-	return batch.Send()
+	if err := batch.Send(); err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+// Upsert inserts an entity into the table.
+// ClickHouse has no unique constraints, so replacing of rows with the same conflict fields
+// is left to the table engine: the table should be a ReplacingMergeTree
+// whose sorting key consists of columns of conflict fields.
+// Until parts are merged, use a query with the FINAL modifier to read deduplicated rows.
+func (r *Repo[T]) Upsert(ctx context.Context, d hohin.DB, entity T, conflictFields ...string) error {
+	return r.UpsertMany(ctx, d, []T{entity}, conflictFields...)
+}
+
+// UpsertMany inserts several entities into the table. See [Repo.Upsert] for details.
+func (r *Repo[T]) UpsertMany(ctx context.Context, d hohin.DB, entities []T, conflictFields ...string) error {
+	if len(conflictFields) == 0 {
+		return errors.New("conflict fields are required for upsert")
+	}
+	for _, f := range conflictFields {
+		if _, ok := r.mapping[f]; !ok {
+			return fmt.Errorf("unknown field `%s` in conflict fields", f)
+		}
+	}
+	return r.AddMany(ctx, d, entities)
 }
 
+// Update saves an updated entity.
+// It returns hohin.NotFound if there are no entities matching a given filter.
 func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) error {
+	count, err := r.UpdateCount(ctx, d, f, entity)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return hohin.NotFound
+	}
+	return nil
+}
+
+// UpdateCount saves an updated entity using ALTER TABLE ... UPDATE.
+// The number of updated records is counted before the mutation is executed.
+func (r *Repo[T]) UpdateCount(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) (uint64, error) {
 	db := d.(*DB)
 	data, err := r.dump(entity)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	count, err := r.Count(ctx, d, f)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
 	}
 	oldEntity, err := r.Get(ctx, d, f)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	oldData, err := r.dump(oldEntity)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	sql := NewSQL("ALTER TABLE ", r.table, " UPDATE ")
+	changes := 0
 	for k, v := range data {
 		changed := false
 		switch val := v.(type) {
@@ -364,25 +581,86 @@ func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity
 		}
 		if changed {
 			sql.Add(k, " = ").Param(v).Add(", ")
+			changes += 1
 		}
 	}
-	sql.RemoveLast().Add(" WHERE ")
-	err = r.applyFilter(sql, f)
-	if err != nil {
-		return err
-	}
-	query, params := sql.Build()
-	if err := db.conn.Exec(ctx, query, params...); err != nil {
-		return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+	if changes > 0 {
+		sql.RemoveLast().Add(" WHERE ")
+		err = r.applyFilter(sql, f)
+		if err != nil {
+			return 0, err
+		}
+		query, params := sql.Build()
+		if err := db.conn.Exec(ctx, query, params...); err != nil {
+			return 0, queryError(query, err)
+		}
 	}
 	if r.afterUpdate != nil {
 		for _, sql := range r.afterUpdate(entity) {
 			query, params := sql.Build()
 			if err := db.conn.Exec(ctx, query, params...); err != nil {
-				return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+				return 0, queryError(query, err)
 			}
 		}
 	}
+	return count, nil
+}
+
+// UpdateFields changes fields of entities matching a given filter using ALTER TABLE ... UPDATE.
+// The number of updated entities is counted before the mutation is executed.
+func (r *Repo[T]) UpdateFields(ctx context.Context, d hohin.DB, f hohin.Filter, set hohin.Set) (uint64, error) {
+	db := d.(*DB)
+	sql := NewSQL("ALTER TABLE ", r.table, " UPDATE ")
+	if err := r.applySet(sql, set); err != nil {
+		return 0, err
+	}
+	var count uint64
+	var err error
+	sql.Add(" WHERE ")
+	if f.Operation != "" {
+		if err := r.applyFilter(sql, f); err != nil {
+			return 0, err
+		}
+		count, err = r.Count(ctx, d, f)
+	} else {
+		sql.Add("1")
+		count, err = r.CountAll(ctx, d)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	query, params := sql.Build()
+	if err := db.conn.Exec(ctx, query, params...); err != nil {
+		return 0, queryError(query, err)
+	}
+	return count, nil
+}
+
+func (r *Repo[T]) applySet(s *sqldb.SQL, set hohin.Set) error {
+	if len(set) == 0 {
+		return errors.New("nothing to update")
+	}
+	for _, a := range set {
+		col, ok := r.mapping[a.Field]
+		if !ok {
+			return fmt.Errorf("unknown field `%s` in a set", a.Field)
+		}
+		switch a.Operation {
+		case operations.Assign:
+			s.Add(col, " = ").Param(a.Value)
+		case operations.Incr:
+			s.Add(col, " = ", col, " + ").Param(a.Value)
+		case operations.SetNull:
+			s.Add(col, " = NULL")
+		default:
+			return fmt.Errorf("operation %s is not supported", a.Operation)
+		}
+		s.Add(", ")
+	}
+	s.RemoveLast()
 	return nil
 }
 
@@ -399,16 +677,97 @@ func (r Repo[T]) Count(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64,
 	row := db.conn.QueryRow(ctx, query, params...)
 	err = row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
 func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T, error) {
-	db := d.(*DB)
 	result := make([]T, 0)
-	sql := NewSQL(r.query)
-	if q.Filter.Operation != "" {
+	err := r.Each(ctx, d, q, func(entity T) error {
+		result = append(result, entity)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *Repo[T]) Each(ctx context.Context, d hohin.DB, q hohin.Query, f func(T) error) error {
+	if q.Cursor != "" {
+		return errors.New("cursors can be used only with GetPage")
+	}
+	return r.each(ctx, d, q, f)
+}
+
+func (r *Repo[T]) GetPage(ctx context.Context, d hohin.DB, q hohin.Query) (hohin.Page[T], error) {
+	result := make([]T, 0)
+	err := r.each(ctx, d, keyset.Query(q, r.key), func(entity T) error {
+		result = append(result, entity)
+		return nil
+	})
+	if err != nil {
+		return hohin.Page[T]{}, err
+	}
+	return keyset.Page(result, q, r.key)
+}
+
+func (r *Repo[T]) each(ctx context.Context, d hohin.DB, q hohin.Query, f func(T) error) error {
+	db := d.(*DB)
+	sql, err := r.buildSelectQuery(q)
+	if err != nil {
+		return err
+	}
+	query, params := sql.Build()
+	rows, err := db.conn.Query(ctx, query, params...)
+	if err != nil {
+		return queryError(query, err)
+	}
+	defer rows.Close()
+	load := r.load
+	if len(q.Fields) > 0 {
+		load = r.loadFields(q.Fields)
+	}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entity, err := load(rows)
+		if err != nil {
+			return err
+		}
+		if err := f(entity); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+func (r *Repo[T]) buildSelectQuery(q hohin.Query) (*sqldb.SQL, error) {
+	if q.Lock.Strength != hohin.NoLock {
+		return nil, fmt.Errorf("%w: ClickHouse cannot lock rows", hohin.ErrUnsupported)
+	}
+	sql, err := r.selectFields(q.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if q.Cursor != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyCursor(sql, q.Cursor, q.Order); err != nil {
+			return nil, err
+		}
+		if q.Filter.Operation != "" {
+			sql.Add(" AND (")
+			if err := r.applyFilter(sql, q.Filter); err != nil {
+				return nil, err
+			}
+			sql.Add(")")
+		}
+	} else if q.Filter.Operation != "" {
 		sql.Add(" WHERE ")
 		if err := r.applyFilter(sql, q.Filter); err != nil {
 			return nil, err
@@ -417,7 +776,11 @@ func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T,
 	if len(q.Order) > 0 {
 		sql.Add(" ORDER BY ")
 		for _, o := range q.Order {
-			sql.Add(o.Field)
+			col, ok := r.mapping[o.Field]
+			if !ok {
+				return nil, fmt.Errorf("unknown field `%s` in an order", o.Field)
+			}
+			sql.Add(col)
 			if o.Desc {
 				sql.Add(" DESC")
 			}
@@ -431,21 +794,67 @@ func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T,
 	if q.Offset > 0 {
 		sql.Add(" OFFSET ").Param(q.Offset)
 	}
-	query, params := sql.Build()
-	rows, err := db.conn.Query(ctx, query, params...)
-	if err != nil {
-		return nil, fmt.Errorf("cannot execute query `%s`: %w", query, err)
+	return sql, nil
+}
+
+// selectFields starts a query that selects columns of given fields
+// or all columns if no fields are given.
+func (r *Repo[T]) selectFields(fields []string) (*sqldb.SQL, error) {
+	if len(fields) == 0 {
+		return NewSQL(r.query), nil
 	}
-	defer rows.Close()
-	for rows.Next() {
-		entity, err := r.load(rows)
-		if err != nil {
-			return nil, err
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		col, ok := r.mapping[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field `%s` in a projection", field)
 		}
-		result = append(result, entity)
+		columns = append(columns, col)
+	}
+	sql := NewSQL("SELECT ").Join(", ", columns...)
+	if r.queryCustomized {
+		sql.Add(" FROM (", r.query, ") AS q")
+	} else {
+		sql.Add(" FROM ", r.table)
 	}
+	return sql, nil
+}
 
-	return result, nil
+// loadFields returns a function that loads only given fields of an entity.
+func (r *Repo[T]) loadFields(names []string) func(Scanner) (T, error) {
+	return func(row Scanner) (T, error) {
+		var entity T
+		v := reflect.ValueOf(&entity).Elem()
+		dest := make([]any, 0, len(names))
+		for _, field := range names {
+			f := fields.ByName(v, field)
+			if !f.IsValid() {
+				return entity, fmt.Errorf("unknown field `%s` in a projection", field)
+			}
+			dest = append(dest, f.Addr().Interface())
+		}
+		err := row.Scan(dest...)
+		return entity, err
+	}
+}
+
+func (r *Repo[T]) applyCursor(s *sqldb.SQL, c hohin.Cursor, order []hohin.Order) error {
+	values, err := keyset.Values[T](c, order)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(order))
+	desc := make([]bool, 0, len(order))
+	for _, o := range order {
+		col, ok := r.mapping[o.Field]
+		if !ok {
+			return fmt.Errorf("unknown field `%s` in an order", o.Field)
+		}
+		columns = append(columns, col)
+		desc = append(desc, o.Desc)
+	}
+	s.Keyset(columns, desc, values)
+	return nil
 }
 
 func (r *Repo[T]) GetFirst(ctx context.Context, d hohin.DB, q hohin.Query) (T, error) {
@@ -468,17 +877,152 @@ func (r *Repo[T]) CountAll(ctx context.Context, d hohin.DB) (uint64, error) {
 	row := db.conn.QueryRow(ctx, query)
 	err := row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
+// Sum returns a sum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Sum(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "sumOrNull", field, f)
+}
+
+// Avg returns an average of values of a field of entities matching a given filter.
+func (r *Repo[T]) Avg(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "avgOrNull", field, f)
+}
+
+// Min returns a minimum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Min(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "minOrNull", field, f)
+}
+
+// Max returns a maximum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Max(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "maxOrNull", field, f)
+}
+
+// aggregateColumn returns an SQL expression that applies an aggregate function to a column
+// and converts a result to a string, so it can be parsed as a decimal.Decimal.
+func aggregateColumn(function string, col string) string {
+	return "ifNull(toString(" + function + "(" + col + ")), '0')"
+}
+
+func (r *Repo[T]) aggregateField(ctx context.Context, d hohin.DB, function string, field string, f hohin.Filter) (decimal.Decimal, error) {
+	db := d.(*DB)
+	col, ok := r.mapping[field]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("unknown field `%s` in an aggregation", field)
+	}
+	sql := NewSQL("SELECT ", aggregateColumn(function, col), " FROM (", r.query)
+	if f.Operation != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyFilter(sql, f); err != nil {
+			return decimal.Zero, err
+		}
+	}
+	sql.Add(") AS q")
+	query, params := sql.Build()
+	var result string
+	row := db.conn.QueryRow(ctx, query, params...)
+	if err := row.Scan(&result); err != nil {
+		return decimal.Zero, queryError(query, err)
+	}
+	return decimal.NewFromString(result)
+}
+
+func (r *Repo[T]) Aggregate(ctx context.Context, d hohin.DB, a hohin.Aggregation) ([]hohin.Group, error) {
+	db := d.(*DB)
+	col, ok := r.mapping[a.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field `%s` in an aggregation", a.Field)
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	groupColumns := make([]string, 0, len(a.GroupBy))
+	keyTypes := make([]reflect.Type, 0, len(a.GroupBy))
+	for _, field := range a.GroupBy {
+		groupCol, ok := r.mapping[field]
+		keyType, found := fields.TypeByName(t, field)
+		if !ok || !found {
+			return nil, fmt.Errorf("unknown field `%s` in a grouping", field)
+		}
+		groupColumns = append(groupColumns, groupCol)
+		keyTypes = append(keyTypes, keyType)
+	}
+
+	sql := NewSQL("SELECT ")
+	for _, groupCol := range groupColumns {
+		sql.Add(groupCol, ", ")
+	}
+	sql.Add("count(), ")
+	sql.Join(", ",
+		aggregateColumn("sumOrNull", col),
+		aggregateColumn("avgOrNull", col),
+		aggregateColumn("minOrNull", col),
+		aggregateColumn("maxOrNull", col),
+	)
+	sql.Add(" FROM (", r.query)
+	if a.Filter.Operation != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyFilter(sql, a.Filter); err != nil {
+			return nil, err
+		}
+	}
+	sql.Add(") AS q")
+	if len(groupColumns) > 0 {
+		sql.Add(" GROUP BY ").Join(", ", groupColumns...)
+		sql.Add(" ORDER BY ").Join(", ", groupColumns...)
+	}
+
+	query, params := sql.Build()
+	rows, err := db.conn.Query(ctx, query, params...)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+	result := make([]hohin.Group, 0)
+	for rows.Next() {
+		keys := make([]any, 0, len(keyTypes))
+		for _, keyType := range keyTypes {
+			keys = append(keys, reflect.New(keyType).Interface())
+		}
+		var count uint64
+		var aggregates [4]string
+		dest := append(keys, &count, &aggregates[0], &aggregates[1], &aggregates[2], &aggregates[3])
+		if err := rows.Scan(dest...); err != nil {
+			return nil, queryError(query, err)
+		}
+		var values [4]decimal.Decimal
+		for i, aggregate := range aggregates {
+			value, err := decimal.NewFromString(aggregate)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		group := hohin.Group{Key: make([]any, 0, len(keys))}
+		for _, key := range keys {
+			group.Key = append(group.Key, reflect.ValueOf(key).Elem().Interface())
+		}
+		group.Count = count
+		group.Sum = values[0]
+		group.Avg = values[1]
+		group.Min = values[2]
+		group.Max = values[3]
+		result = append(result, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(query, err)
+	}
+	return result, nil
+}
+
 func (r *Repo[T]) Clear(ctx context.Context, d hohin.DB) error {
 	db := d.(*DB)
 	query := NewSQL("TRUNCATE TABLE ", r.table).String()
 	err := db.conn.Exec(ctx, query)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return err
 }
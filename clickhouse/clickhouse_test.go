@@ -2,11 +2,13 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/google/uuid"
 	"github.com/meowmeowcode/hohin"
 	"github.com/shopspring/decimal"
 	"net/netip"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -109,6 +111,19 @@ func addEve(db hohin.SimpleDB, repo hohin.SimpleRepo[User]) User {
 	return u
 }
 
+func TestVersionField(t *testing.T) {
+	type Document struct {
+		Id      uuid.UUID
+		Version int `hohin:",version"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Version field was accepted")
+		}
+	}()
+	NewRepo(Conf[Document]{Table: "documents"})
+}
+
 func TestRepo(t *testing.T) {
 	conn, err := clickhouse.Open(&clickhouse.Options{
 		Addr: []string{"localhost:9000"},
@@ -221,6 +236,27 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestGetOne", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		addBob(db, repo)
+		u, err := repo.GetOne(db, hohin.Eq("Name", "Alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.Equal(&alice) {
+			t.Fatalf("%v != %v", alice, u)
+		}
+		_, err = repo.GetOne(db, hohin.Eq("Name", "Eve"))
+		if err != hohin.NotFound {
+			t.Fatalf("%v != %v", err, hohin.NotFound)
+		}
+		_, err = repo.GetOne(db, hohin.Or(hohin.Eq("Name", "Alice"), hohin.Eq("Name", "Bob")))
+		if err != hohin.ErrMultipleFound {
+			t.Fatalf("%v != %v", err, hohin.ErrMultipleFound)
+		}
+	})
+
 	t.Run("TestGetForUpdate", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -245,6 +281,26 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestLocks", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		u, err := repo.GetLocked(db, hohin.Eq("Id", alice.Id), hohin.Lock{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.Equal(&alice) {
+			t.Fatalf("%v != %v", alice, u)
+		}
+		_, err = repo.GetLocked(db, hohin.Eq("Id", alice.Id), hohin.Lock{Strength: hohin.ForUpdate})
+		if !errors.Is(err, hohin.ErrUnsupported) {
+			t.Fatalf("%v is not %v", err, hohin.ErrUnsupported)
+		}
+		_, err = repo.GetMany(db, hohin.Query{}.SkipLocked())
+		if !errors.Is(err, hohin.ErrUnsupported) {
+			t.Fatalf("%v is not %v", err, hohin.ErrUnsupported)
+		}
+	})
+
 	t.Run("TestExists", func(t *testing.T) {
 		cleanDB()
 		addAlice(db, repo)
@@ -300,6 +356,81 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestUpdateFields", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+		count, err := repo.UpdateFields(
+			db,
+			hohin.Contains("Name", "e"),
+			hohin.Set{hohin.Incr("Age", 2), hohin.Assign("Active", false)},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		alice.Age += 2
+		alice.Active = false
+		eve.Age += 2
+		users, err := repo.GetMany(db, hohin.Query{}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+		_, err = repo.UpdateFields(db, hohin.Eq("Name", "Bob"), hohin.Set{hohin.Assign("Test", 1)})
+		if err == nil {
+			t.Fatal("unknown field is accepted")
+		}
+	})
+
+	t.Run("TestUpdateCount", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		bob := addBob(db, repo)
+		bob.Name = "Robert"
+		count, err := repo.UpdateCount(db, hohin.Eq("Id", bob.Id), bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+		count, err = repo.UpdateCount(db, hohin.Eq("Name", "Eve"), bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%v != 0", count)
+		}
+	})
+
+	t.Run("TestDeleteCount", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		addBob(db, repo)
+		addEve(db, repo)
+		count, err := repo.DeleteCount(db, hohin.Contains("Name", "e"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		count, err = repo.CountAll(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+	})
+
 	t.Run("TestDelete", func(t *testing.T) {
 		cleanDB()
 		addAlice(db, repo)
@@ -333,6 +464,72 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestAggregates", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		addBob(db, repo)
+		addEve(db, repo)
+
+		sum, err := repo.Sum(db, "Age", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.Equal(decimal.NewFromInt(86)) {
+			t.Fatalf("%v != 86", sum)
+		}
+
+		avg, err := repo.Avg(db, "Age", hohin.In("Name", []any{"Alice", "Bob"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !avg.Equal(decimal.NewFromInt(25)) {
+			t.Fatalf("%v != 25", avg)
+		}
+
+		min, err := repo.Min(db, "Money", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !min.Equal(decimal.RequireFromString("120.50")) {
+			t.Fatalf("%v != 120.50", min)
+		}
+
+		max, err := repo.Max(db, "Money", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !max.Equal(decimal.RequireFromString("168.31")) {
+			t.Fatalf("%v != 168.31", max)
+		}
+
+		sum, err = repo.Sum(db, "Age", hohin.Eq("Name", "Nobody"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.IsZero() {
+			t.Fatalf("%v != 0", sum)
+		}
+
+		groups, err := repo.Aggregate(db, hohin.Aggregation{Field: "Age", GroupBy: []string{"Active"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 2 {
+			t.Fatalf("%d != 2", len(groups))
+		}
+		if groups[0].Key[0] != false || groups[0].Count != 1 || !groups[0].Sum.Equal(decimal.NewFromInt(36)) {
+			t.Fatalf("unexpected group %v", groups[0])
+		}
+		if groups[1].Key[0] != true ||
+			groups[1].Count != 2 ||
+			!groups[1].Sum.Equal(decimal.NewFromInt(50)) ||
+			!groups[1].Avg.Equal(decimal.NewFromInt(25)) ||
+			!groups[1].Min.Equal(decimal.NewFromInt(23)) ||
+			!groups[1].Max.Equal(decimal.NewFromInt(27)) {
+			t.Fatalf("unexpected group %v", groups[1])
+		}
+	})
+
 	t.Run("TestLimit", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -388,6 +585,146 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestEach", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		users := []User{}
+		err := repo.Each(db, hohin.Query{}.OrderBy(hohin.Asc("Name")), func(u User) error {
+			users = append(users, u)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+
+		stop := errors.New("stop")
+		users = []User{}
+		err = repo.Each(db, hohin.Query{}.OrderBy(hohin.Asc("Name")), func(u User) error {
+			users = append(users, u)
+			if len(users) == 2 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) {
+			t.Fatalf("%v is not %v", err, stop)
+		}
+		expectedUsers = []User{alice, bob}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+	})
+
+	t.Run("TestGetPage", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		page, err := repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next == "" || page.Prev != "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		page, err = repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")).After(page.Next))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers = []User{eve}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next != "" || page.Prev == "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		page, err = repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")).Before(page.Prev))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers = []User{alice, bob}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next == "" || page.Prev != "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		users := []User{}
+		q := hohin.Query{Limit: 1}.OrderBy(hohin.Desc("Active"), hohin.Asc("Name"))
+		for {
+			page, err := repo.GetPage(db, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			users = append(users, page.Items...)
+			if page.Next == "" {
+				break
+			}
+			q = q.After(page.Next)
+		}
+		expectedUsers = []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+	})
+
+	t.Run("TestProjection", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		q := hohin.Query{}.OrderBy(hohin.Asc("Name"))
+		users, err := repo.GetMany(db, q.Select("Id", "Name"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{
+			{Id: alice.Id, Name: alice.Name},
+			{Id: bob.Id, Name: bob.Name},
+			{Id: eve.Id, Name: eve.Name},
+		}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+
+		type UserName struct {
+			Name string
+		}
+		names, err := hohin.SimpleProject[User, UserName](db, repo, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedNames := []UserName{{"Alice"}, {"Bob"}, {"Eve"}}
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Fatalf("%v != %v", names, expectedNames)
+		}
+
+		ages, err := hohin.SimplePluck[User, int](db, repo, "Age", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedAges := []int{23, 27, 36}
+		if !reflect.DeepEqual(ages, expectedAges) {
+			t.Fatalf("%v != %v", ages, expectedAges)
+		}
+	})
+
 	t.Run("TestFilters", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -426,6 +763,18 @@ func TestRepo(t *testing.T) {
 				filter: hohin.In("Age", []any{alice.Age, eve.Age}),
 				result: []User{alice, eve},
 			},
+			{
+				filter: hohin.In("Age", []any{}),
+				result: []User{},
+			},
+			{
+				filter: hohin.NotIn("Age", []any{alice.Age, eve.Age}),
+				result: []User{bob},
+			},
+			{
+				filter: hohin.Between("Age", alice.Age, bob.Age),
+				result: []User{alice, bob},
+			},
 			// float64 operations:
 			{
 				filter: hohin.Eq("Weight", bob.Weight),
@@ -439,6 +788,10 @@ func TestRepo(t *testing.T) {
 				filter: hohin.Lt("Weight", bob.Weight),
 				result: []User{alice},
 			},
+			{
+				filter: hohin.Between("Weight", alice.Weight, bob.Weight),
+				result: []User{alice, bob},
+			},
 			{
 				filter: hohin.Gt("Weight", bob.Weight),
 				result: []User{eve},
@@ -518,6 +871,18 @@ func TestRepo(t *testing.T) {
 				filter: hohin.In("Name", []any{"Alice", "Bob"}),
 				result: []User{alice, bob},
 			},
+			{
+				filter: hohin.In("Name", []string{"Alice", "Eve"}),
+				result: []User{alice, eve},
+			},
+			{
+				filter: hohin.NotIn("Name", []string{"Alice", "Bob"}),
+				result: []User{eve},
+			},
+			{
+				filter: hohin.NotIn("Name", []string{}),
+				result: []User{alice, bob, eve},
+			},
 			{
 				filter: hohin.HasPrefix("Name", "A"),
 				result: []User{alice},
@@ -554,6 +919,58 @@ func TestRepo(t *testing.T) {
 				filter: hohin.IContains("Name", "O"),
 				result: []User{bob},
 			},
+			{
+				filter: hohin.Contains("Name", "%"),
+				result: []User{},
+			},
+			{
+				filter: hohin.IHasPrefix("Name", "_"),
+				result: []User{},
+			},
+			{
+				filter: hohin.HasSuffix("Name", "\\"),
+				result: []User{},
+			},
+			{
+				filter: hohin.Like("Name", "A%"),
+				result: []User{alice},
+			},
+			{
+				filter: hohin.Like("Name", "_ve"),
+				result: []User{eve},
+			},
+			{
+				filter: hohin.Like("Name", "b%"),
+				result: []User{},
+			},
+			{
+				filter: hohin.ILike("Name", "b%"),
+				result: []User{bob},
+			},
+			{
+				filter: hohin.Like("Name", hohin.EscapeLike("_ve")),
+				result: []User{},
+			},
+			{
+				filter: hohin.Matches("Name", "^[AE].*e$"),
+				result: []User{alice, eve},
+			},
+			{
+				filter: hohin.Matches("Name", "^a"),
+				result: []User{},
+			},
+			{
+				filter: hohin.IMatches("Name", "^a"),
+				result: []User{alice},
+			},
+			{
+				filter: hohin.Matches("Name", "o|v"),
+				result: []User{bob, eve},
+			},
+			{
+				filter: hohin.IMatches("Name", "[[:upper:]]O"),
+				result: []User{bob},
+			},
 			// time.Time operations:
 			{
 				filter: hohin.Eq("RegisteredAt", eve.RegisteredAt),
@@ -588,6 +1005,10 @@ func TestRepo(t *testing.T) {
 				filter: hohin.Ne("Id", eve.Id),
 				result: []User{alice, bob},
 			},
+			{
+				filter: hohin.In("Id", []uuid.UUID{alice.Id, eve.Id}),
+				result: []User{alice, eve},
+			},
 			// Network operations:
 			{
 				filter: hohin.IPWithin("IpAddress", "192.168.1.0/24"),
@@ -620,6 +1041,10 @@ func TestRepo(t *testing.T) {
 				t.Errorf("filter: %v; expected result: %v; actual result: %v", cs.filter, cs.result, result)
 			}
 		}
+		_, err := repo.GetMany(db, hohin.Query{Filter: hohin.Matches("Name", `\d`)})
+		if err == nil {
+			t.Fatal("Invalid pattern was accepted")
+		}
 	})
 
 	t.Run("TestGetFirst", func(t *testing.T) {
@@ -682,6 +1107,44 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestUpsert", func(t *testing.T) {
+		if err := conn.Exec(ctx, `DROP TABLE IF EXISTS counters`); err != nil {
+			t.Fatal(err)
+		}
+		err := conn.Exec(ctx, `
+			CREATE TABLE counters (
+				Key String NOT NULL,
+				Value Int64 NOT NULL
+			) ENGINE = ReplacingMergeTree() ORDER BY Key
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Counter struct {
+			Key   string
+			Value int64
+		}
+		countersRepo := NewRepo(Conf[Counter]{
+			Table: "counters",
+			Query: "SELECT Key, Value FROM counters FINAL",
+		}).Simple()
+		if err := countersRepo.Upsert(db, Counter{Key: "a", Value: 1}, "Key"); err != nil {
+			t.Fatal(err)
+		}
+		err = countersRepo.UpsertMany(db, []Counter{{Key: "a", Value: 2}, {Key: "b", Value: 1}}, "Key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters, err := countersRepo.GetMany(db, hohin.Query{}.OrderBy(hohin.Asc("Key")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Counter{{Key: "a", Value: 2}, {Key: "b", Value: 1}}
+		if !reflect.DeepEqual(counters, expected) {
+			t.Fatalf("%v != %v", counters, expected)
+		}
+	})
+
 	t.Run("NullTest", func(t *testing.T) {
 		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS options`)
 		if err != nil {
@@ -720,5 +1183,396 @@ func TestRepo(t *testing.T) {
 		if count != 2 {
 			t.Fatalf("%v != 2", count)
 		}
+		count, err = optionsRepo.UpdateFields(db, hohin.Not(hohin.IsNull("Value")), hohin.Set{hohin.SetNull("Value")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		count, err = optionsRepo.Count(db, hohin.IsNull("Value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatalf("%v != 3", count)
+		}
+	})
+
+	t.Run("TestStructTags", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS products`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE products (
+				id UUID NOT NULL,
+				title String NOT NULL,
+				serial Int64 NOT NULL DEFAULT 7
+			) ENGINE = MergeTree() ORDER BY id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Product struct {
+			Id      uuid.UUID `hohin:"id,pk"`
+			Title   string    `hohin:"title"`
+			Serial  int       `hohin:"serial,readonly"`
+			Comment string    `hohin:"-"`
+		}
+		productsRepo := NewRepo(Conf[Product]{Table: "products"}).Simple()
+
+		product := Product{Id: uuid.New(), Title: "Pen", Serial: 100, Comment: "blue"}
+		if err := productsRepo.Add(db, product); err != nil {
+			t.Fatal(err)
+		}
+		p, err := productsRepo.Get(db, hohin.Eq("Title", "Pen"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Product{Id: product.Id, Title: "Pen", Serial: 7}
+		if p != expected {
+			t.Fatalf("%v != %v", p, expected)
+		}
+
+		p.Title = "Pencil"
+		p.Serial = 8
+		if err := productsRepo.Update(db, hohin.Eq("Id", p.Id), p); err != nil {
+			t.Fatal(err)
+		}
+		p, err = productsRepo.Get(db, hohin.Eq("Id", p.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = Product{Id: product.Id, Title: "Pencil", Serial: 7}
+		if p != expected {
+			t.Fatalf("%v != %v", p, expected)
+		}
+	})
+
+	t.Run("TestNestedStructs", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS customers`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE customers (
+				Id UUID NOT NULL,
+				Name String NOT NULL,
+				CreatedAt DateTime64 NOT NULL,
+				address_city String NOT NULL,
+				address_zip String NOT NULL
+			) ENGINE = MergeTree() ORDER BY CreatedAt
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Timestamps struct {
+			CreatedAt time.Time
+		}
+		type Address struct {
+			City string `hohin:"city"`
+			Zip  string `hohin:"zip"`
+		}
+		type Customer struct {
+			Id   uuid.UUID
+			Name string
+			Timestamps
+			Address Address `hohin:"address"`
+		}
+		customersRepo := NewRepo(Conf[Customer]{Table: "customers"}).Simple()
+
+		customer := Customer{
+			Id:         uuid.New(),
+			Name:       "Alice",
+			Timestamps: Timestamps{CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)},
+			Address:    Address{City: "Paris", Zip: "75001"},
+		}
+		if err := customersRepo.Add(db, customer); err != nil {
+			t.Fatal(err)
+		}
+		c, err := customersRepo.Get(db, hohin.Eq("Address.City", "Paris"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != customer {
+			t.Fatalf("%v != %v", c, customer)
+		}
+		count, err := customersRepo.Count(db, hohin.Eq("Address.City", "London"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%v != 0", count)
+		}
+	})
+
+	t.Run("TestNullableFields", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS notes`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE notes (
+				Id UUID NOT NULL,
+				Text Nullable(String),
+				Rating Nullable(Int64),
+				ReadAt Nullable(DateTime64)
+			) ENGINE = MergeTree() ORDER BY Id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Note struct {
+			Id     uuid.UUID
+			Text   *string
+			Rating *int64
+			ReadAt *time.Time
+		}
+		notesRepo := NewRepo(Conf[Note]{Table: "notes"}).Simple()
+
+		text := "hello"
+		rating := int64(5)
+		readAt := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+		note := Note{Id: uuid.New(), Text: &text, Rating: &rating, ReadAt: &readAt}
+		if err := notesRepo.Add(db, note); err != nil {
+			t.Fatal(err)
+		}
+		if err := notesRepo.Add(db, Note{Id: uuid.New()}); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := notesRepo.Get(db, hohin.Eq("Id", note.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *n.Text != text || *n.Rating != rating || !n.ReadAt.Equal(readAt) {
+			t.Fatalf("%v != %v", n, note)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.IsNull("Text"), 1},
+			{hohin.IsNull("Rating"), 1},
+			{hohin.IsNotNull("Text"), 1},
+			{hohin.In("Rating", []any{5, nil}), 1},
+			{hohin.NotIn("Rating", []int64{1, 2}), 1},
+			{hohin.Between("Rating", 1, 5), 1},
+			{hohin.Eq("Text", text), 1},
+			{hohin.Eq("Text", &text), 1},
+			{hohin.Ne("Text", text), 0},
+			{hohin.Not(hohin.Eq("Text", text)), 0},
+			{hohin.Gt("Rating", 3), 1},
+			{hohin.Lt("ReadAt", readAt.Add(time.Hour)), 1},
+			{hohin.Or(hohin.Eq("Text", "bye"), hohin.IsNull("ReadAt")), 1},
+		}
+		for _, c := range cases {
+			count, err := notesRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
+	})
+
+	t.Run("TestErrors", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS scores`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE scores (
+				Id UUID NOT NULL,
+				Value Int64 NOT NULL,
+				CONSTRAINT positive_value CHECK Value > 0
+			) ENGINE = MergeTree() ORDER BY Id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Score struct {
+			Id    uuid.UUID
+			Value int64
+		}
+		scoresRepo := NewRepo(Conf[Score]{Table: "scores"}).Simple()
+		err = scoresRepo.Add(db, Score{Id: uuid.New(), Value: -1})
+		if !errors.Is(err, hohin.ErrCheckViolation) {
+			t.Fatalf("%v is not a check violation", err)
+		}
+		var dbErr *hohin.DBError
+		if !errors.As(err, &dbErr) {
+			t.Fatalf("%v is not a DBError", err)
+		}
+	})
+
+	t.Run("TestTxHooks", func(t *testing.T) {
+		var events []string
+		record := func(event string) func() {
+			return func() {
+				events = append(events, event)
+			}
+		}
+		err := db.Transaction(func(db hohin.SimpleDB) error {
+			db.AfterCommit(record("commit"))
+			db.AfterRollback(record("rollback"))
+			err := db.Transaction(func(db hohin.SimpleDB) error {
+				db.AfterCommit(record("failed nested commit"))
+				db.AfterRollback(record("failed nested rollback"))
+				return errors.New("fail")
+			})
+			if err == nil {
+				t.Fatal("Nested transaction didn't fail")
+			}
+			return db.Transaction(func(db hohin.SimpleDB) error {
+				db.AfterCommit(record("nested commit"))
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"failed nested rollback", "commit", "nested commit"}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("%v != %v", events, expected)
+		}
+
+		events = nil
+		err = db.Transaction(func(db hohin.SimpleDB) error {
+			db.AfterCommit(record("commit"))
+			db.AfterRollback(record("rollback"))
+			return errors.New("fail")
+		})
+		if err == nil {
+			t.Fatal("Transaction didn't fail")
+		}
+		db.AfterCommit(record("no transaction"))
+		expected = []string{"rollback", "no transaction"}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("%v != %v", events, expected)
+		}
+	})
+
+	t.Run("TestFieldRefs", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS budgets`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE budgets (
+				Id UUID NOT NULL,
+				Owner String NOT NULL,
+				Manager String NOT NULL,
+				Planned Int64 NOT NULL,
+				Spent Int64 NOT NULL
+			) ENGINE = MergeTree() ORDER BY Id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Budget struct {
+			Id      uuid.UUID
+			Owner   string
+			Manager string
+			Planned int
+			Spent   int
+		}
+		budgetsRepo := NewRepo(Conf[Budget]{Table: "budgets"}).Simple()
+		budgets := []Budget{
+			{Id: uuid.New(), Owner: "Alice", Manager: "alice", Planned: 100, Spent: 50},
+			{Id: uuid.New(), Owner: "Bob", Manager: "Eve", Planned: 100, Spent: 100},
+			{Id: uuid.New(), Owner: "Eve", Manager: "Eve", Planned: 100, Spent: 150},
+		}
+		if err := budgetsRepo.AddMany(db, budgets); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.Eq("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Ne("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Gt("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Gte("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Lt("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Lte("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Eq("Owner", hohin.FieldRef("Manager")), 1},
+			{hohin.IEq("Owner", hohin.FieldRef("Manager")), 2},
+			{hohin.INe("Owner", hohin.FieldRef("Manager")), 1},
+		}
+		for _, c := range cases {
+			count, err := budgetsRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
+
+		_, err = budgetsRepo.Count(db, hohin.Gt("Spent", hohin.FieldRef("Unknown")))
+		if err == nil {
+			t.Fatal("Unknown field was accepted")
+		}
+		_, err = budgetsRepo.Count(db, hohin.In("Owner", hohin.FieldRef("Manager")))
+		if err == nil {
+			t.Fatal("Unsupported operation was accepted")
+		}
+	})
+
+	t.Run("TestArrays", func(t *testing.T) {
+		err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS articles`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.Exec(context.Background(), `
+			CREATE TABLE articles (
+				Id UUID NOT NULL,
+				Tags Array(String) NOT NULL
+			) ENGINE = MergeTree() ORDER BY Id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Article struct {
+			Id   uuid.UUID
+			Tags []string
+		}
+		articlesRepo := NewRepo(Conf[Article]{Table: "articles"}).Simple()
+		articles := []Article{
+			{Id: uuid.New(), Tags: []string{"go", "sql"}},
+			{Id: uuid.New(), Tags: []string{"go"}},
+			{Id: uuid.New(), Tags: []string{}},
+		}
+		if err := articlesRepo.AddMany(db, articles); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.HasElement("Tags", "go"), 2},
+			{hohin.HasElement("Tags", "sql"), 1},
+			{hohin.Not(hohin.HasElement("Tags", "sql")), 2},
+			{hohin.HasAny("Tags", []string{"sql", "rust"}), 1},
+			{hohin.HasAny("Tags", []string{}), 0},
+			{hohin.HasAll("Tags", []string{"go", "sql"}), 1},
+			{hohin.HasAll("Tags", []any{"go"}), 2},
+			{hohin.ArrayLen("Tags", 1), 1},
+		}
+		for _, c := range cases {
+			count, err := articlesRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
 	})
 }
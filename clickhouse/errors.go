@@ -0,0 +1,33 @@
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/proto"
+	"github.com/meowmeowcode/hohin"
+)
+
+// kinds maps ClickHouse exception codes to kinds of hohin errors.
+var kinds = map[int32]error{
+	469: hohin.ErrCheckViolation, // VIOLATED_CONSTRAINT
+	473: hohin.ErrDeadlock,       // DEADLOCK_AVOIDED
+	125: hohin.ErrTooManyResults, // INCORRECT_RESULT_OF_SCALAR_SUBQUERY
+}
+
+// classify wraps a ClickHouse exception into a hohin.DBError if its kind is known.
+func classify(err error) error {
+	var exception *proto.Exception
+	if !errors.As(err, &exception) {
+		return err
+	}
+	kind, ok := kinds[exception.Code]
+	if !ok {
+		return err
+	}
+	return &hohin.DBError{Kind: kind, Err: err}
+}
+
+// queryError returns an error of a failed query.
+func queryError(query string, err error) error {
+	return fmt.Errorf("cannot execute query `%s`: %w", query, classify(err))
+}
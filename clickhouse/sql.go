@@ -7,7 +7,7 @@ import (
 type clickHouseDialect struct{}
 
 func (d clickHouseDialect) ProcessParam(p any, number int) (string, any) {
-	return "?", p
+	return "?", sqldb.Deref(p)
 }
 
 var dialect clickHouseDialect
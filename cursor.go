@@ -0,0 +1,48 @@
+package hohin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor is an opaque position in an ordered list of entities.
+// It is used for keyset pagination and can be safely passed in URLs.
+type Cursor string
+
+// NewCursor creates a [Cursor] from values of fields by which entities are ordered.
+func NewCursor(values ...any) (Cursor, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode cursor: %w", err)
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(data)), nil
+}
+
+// Decode stores values kept in a [Cursor] into variables pointed to by given pointers.
+func (c Cursor) Decode(dest ...any) error {
+	data, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	if len(values) != len(dest) {
+		return fmt.Errorf("invalid cursor: expected %d values, got %d", len(dest), len(values))
+	}
+	for i, value := range values {
+		if err := json.Unmarshal(value, dest[i]); err != nil {
+			return fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	return nil
+}
+
+// Page is a part of entities retrieved with keyset pagination.
+type Page[T any] struct {
+	Items []T    // entities of the page
+	Next  Cursor // cursor to get the next page, empty if there is no next page
+	Prev  Cursor // cursor to get the previous page, empty if there is no previous page
+}
@@ -0,0 +1,89 @@
+package hohin
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Errors used to classify failures of a database independently of its driver.
+// They are wrapped into a [DBError] and can be checked with errors.Is.
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+	ErrCheckViolation      = errors.New("check constraint violation")
+	ErrNotNullViolation    = errors.New("not null constraint violation")
+	ErrSerialization       = errors.New("serialization failure")
+	ErrDeadlock            = errors.New("deadlock")
+	ErrTooManyResults      = errors.New("too many results")
+	ErrReadOnly            = errors.New("write in a read-only transaction")
+)
+
+// ErrUnsupported is returned when a database doesn't support a requested feature.
+var ErrUnsupported = errors.New("operation is not supported by the database")
+
+// DBError is a classified error of a database.
+type DBError struct {
+	Kind       error  // one of the errors like ErrUniqueViolation
+	Constraint string // name of a violated constraint if it's known
+	Column     string // name of a column related to the error if it's known
+	Err        error  // original error of a database driver
+}
+
+func (e *DBError) Error() string {
+	msg := e.Kind.Error()
+	if e.Constraint != "" {
+		msg += fmt.Sprintf(" (constraint `%s`)", e.Constraint)
+	}
+	if e.Column != "" {
+		msg += fmt.Sprintf(" (column `%s`)", e.Column)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Is makes errors.Is report true for the kind of the error.
+func (e *DBError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the original error of a database driver.
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned by a transaction when its function panics
+// and [TxOptions.RecoverPanic] is set.
+type PanicError struct {
+	Value any    // value passed to panic
+	Stack []byte // stack trace of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in a transaction: %v", e.Value)
+}
+
+// CallTx calls a transaction function and returns a *PanicError if it panics.
+// The second result is the same *PanicError, or nil if the function returns normally,
+// so that a panic recovered by the call can be told apart
+// from a *PanicError returned by the function itself.
+// It's intended to be used by implementations of [DB]
+// to roll back transactions whose functions panic.
+func CallTx(f func() error) (err error, panicked *PanicError) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked = &PanicError{Value: v, Stack: debug.Stack()}
+			err = panicked
+		}
+	}()
+	return f(), nil
+}
+
+// Repanic panics again with a value recovered by [CallTx]
+// unless the panic must be returned as an error.
+// Otherwise it returns a given error.
+func Repanic(err error, panicked *PanicError, recoverPanic bool) error {
+	if panicked != nil && !recoverPanic {
+		panic(panicked.Value)
+	}
+	return err
+}
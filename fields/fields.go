@@ -0,0 +1,188 @@
+// Package fields reads descriptions of entity fields from struct tags.
+//
+// A field can be described with a tag like `hohin:"column_name,readonly,pk"`.
+// The first part of the tag is a name of a table column, the field name is used if it's empty.
+// The "readonly" option marks a column generated by the database that must not be written.
+// The "pk" option marks a key field of an entity.
+// The "version" option marks an integer field used for optimistic locking.
+// A field with the `hohin:"-"` tag is not stored at all.
+package fields
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Field is a description of an entity field.
+type Field struct {
+	Name     string // name of a struct field
+	Column   string // name of a table column
+	ReadOnly bool   // defines if the field is generated by the database and must not be written
+	Key      bool   // defines if the field is a key of an entity
+	Version  bool   // defines if the field is a version of an entity used for optimistic locking
+}
+
+// Of returns descriptions of stored fields of a struct type.
+//
+// Fields of embedded structs are included as if they were fields of the struct itself.
+// Fields of nested structs are included with names like "Address.City"
+// and columns like "Address_City", where the column prefix is the column of the nested struct.
+// Structs that are stored as single values, like time.Time or decimal.Decimal, are not flattened.
+func Of(t reflect.Type) []Field {
+	return of(t, "", "")
+}
+
+func of(t reflect.Type, namePrefix string, columnPrefix string) []Field {
+	result := make([]Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag, ok := sf.Tag.Lookup("hohin")
+		if tag == "-" {
+			continue
+		}
+		f := Field{Name: namePrefix + sf.Name, Column: columnPrefix + sf.Name}
+		column := ""
+		if ok {
+			options := strings.Split(tag, ",")
+			column = options[0]
+			if column != "" {
+				f.Column = columnPrefix + column
+			}
+			for _, option := range options[1:] {
+				switch option {
+				case "readonly":
+					f.ReadOnly = true
+				case "pk":
+					f.Key = true
+				case "version":
+					f.Version = true
+				}
+			}
+		}
+		if isComposite(sf.Type) {
+			if sf.Anonymous && column == "" {
+				result = append(result, of(sf.Type, namePrefix, columnPrefix)...)
+			} else {
+				result = append(result, of(sf.Type, f.Name+".", f.Column+"_")...)
+			}
+			continue
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerType       = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isComposite checks if a type is a struct whose fields must be stored separately.
+func isComposite(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return !pt.Implements(valuerType) && !pt.Implements(scannerType) && !pt.Implements(textMarshalerType)
+}
+
+// ByName returns a field of a struct value by its name,
+// which can be a path to a field of a nested struct like "Address.City".
+// It returns the zero Value if there is no such field.
+func ByName(v reflect.Value, name string) reflect.Value {
+	for _, part := range strings.Split(name, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = v.FieldByName(part)
+		if !v.IsValid() {
+			return v
+		}
+	}
+	return v
+}
+
+// TypeByName returns a type of a field of a struct type by its name,
+// which can be a path to a field of a nested struct like "Address.City".
+func TypeByName(t reflect.Type, name string) (reflect.Type, bool) {
+	for _, part := range strings.Split(name, ".") {
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		sf, ok := t.FieldByName(part)
+		if !ok {
+			return nil, false
+		}
+		t = sf.Type
+	}
+	return t, true
+}
+
+// Mapping returns a mapping of field names to column names.
+func Mapping(fs []Field) map[string]string {
+	result := make(map[string]string, len(fs))
+	for _, f := range fs {
+		result[f.Name] = f.Column
+	}
+	return result
+}
+
+// ReadOnly returns a set of names of read-only fields.
+func ReadOnly(fs []Field) map[string]bool {
+	result := make(map[string]bool)
+	for _, f := range fs {
+		if f.ReadOnly {
+			result[f.Name] = true
+		}
+	}
+	return result
+}
+
+// Key returns a name of a key field or an empty string if there is no such field.
+func Key(fs []Field) string {
+	for _, f := range fs {
+		if f.Key {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// Version returns a name of a version field or an empty string if there is no such field.
+func Version(fs []Field) string {
+	for _, f := range fs {
+		if f.Version {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// IsInteger checks if a type can be used for a version field.
+func IsInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// Increment increases a settable integer value by one.
+func Increment(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	}
+}
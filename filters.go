@@ -1,6 +1,13 @@
 package hohin
 
-import "github.com/meowmeowcode/hohin/operations"
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/meowmeowcode/hohin/operations"
+)
 
 // Filter is an object used for filtering entities
 // before getting them from a repository.
@@ -10,6 +17,11 @@ type Filter struct {
 	Value     any                  // value to compare with a field
 }
 
+// FieldRef is a value of a filter that refers to another field of the same entity.
+// It allows to compare fields with each other, for example, Gt("UpdatedAt", FieldRef("CreatedAt")).
+// It's supported by the Eq, IEq, Ne, INe, Lt, Gt, Lte and Gte operations.
+type FieldRef string
+
 // Eq creates a filter to find entities whose field value is equal to a given one.
 func Eq(field string, value any) Filter {
 	return Filter{Field: field, Operation: operations.Eq, Value: value}
@@ -37,6 +49,11 @@ func IsNull(field string) Filter {
 	return Filter{Field: field, Operation: operations.IsNull}
 }
 
+// IsNotNull creates a filter to find entities whose field value is not null.
+func IsNotNull(field string) Filter {
+	return Filter{Field: field, Operation: operations.IsNotNull}
+}
+
 // Lt creates a filter to find entities whose field value is less than a given one.
 func Lt(field string, value any) Filter {
 	return Filter{Field: field, Operation: operations.Lt, Value: value}
@@ -57,11 +74,44 @@ func Gte(field string, value any) Filter {
 	return Filter{Field: field, Operation: operations.Gte, Value: value}
 }
 
-// In creates a filter to find entities whose field value is within a given value.
-func In(field string, value []any) Filter {
+// Between creates a filter to find entities whose field value is
+// greater than or equal to from and less than or equal to to.
+func Between(field string, from, to any) Filter {
+	return Filter{Field: field, Operation: operations.Between, Value: []any{from, to}}
+}
+
+// In creates a filter to find entities whose field value is within a given slice.
+// The slice can be of any type, like []any, []string or []uuid.UUID.
+// A filter with an empty slice matches no entities.
+func In(field string, value any) Filter {
 	return Filter{Field: field, Operation: operations.In, Value: value}
 }
 
+// NotIn creates a filter to find entities whose field value is not within a given slice.
+// The slice can be of any type, like []any, []string or []uuid.UUID.
+// A filter with an empty slice matches all entities.
+func NotIn(field string, value any) Filter {
+	return Filter{Field: field, Operation: operations.NotIn, Value: value}
+}
+
+// Items returns elements of a slice passed to [In], [NotIn], [HasAny] or [HasAll].
+// It's intended to be used by implementations of [Repo].
+// It reports false if the value is not a slice.
+func Items(value any) ([]any, bool) {
+	if items, ok := value.([]any); ok {
+		return items, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	items := make([]any, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, true
+}
+
 // Contains creates a filter to find entities whose field value has a given value.
 func Contains(field string, value string) Filter {
 	return Filter{Field: field, Operation: operations.Contains, Value: value}
@@ -95,12 +145,96 @@ func IHasSuffix(field string, value string) Filter {
 	return Filter{Field: field, Operation: operations.IHasSuffix, Value: value}
 }
 
+// Like creates a filter to find entities whose field value matches a pattern
+// of the LIKE operator of SQL. The % wildcard matches any sequence of characters
+// and the _ wildcard matches a single character.
+// Wildcards and backslashes are matched literally if they are escaped with a backslash,
+// for example, with [EscapeLike].
+// Unlike in Contains, HasPrefix and HasSuffix, wildcards in the pattern aren't escaped.
+func Like(field string, pattern string) Filter {
+	return Filter{Field: field, Operation: operations.Like, Value: pattern}
+}
+
+// ILike creates a case-insensitive filter
+// to find entities whose field value matches a pattern like in [Like].
+func ILike(field string, pattern string) Filter {
+	return Filter{Field: field, Operation: operations.ILike, Value: pattern}
+}
+
+// EscapeLike escapes the wildcards of a [Like] pattern and backslashes with a backslash,
+// so they are matched literally.
+func EscapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Matches creates a filter to find entities
+// whose field value matches a regular expression.
+// A pattern matches any part of a value unless it's anchored with ^ or $.
+//
+// Databases support different dialects of regular expressions,
+// so patterns are limited to the POSIX extended syntax understood by all of them:
+// literal characters, ., bracket expressions like [a-z], [^0-9] and [[:alpha:]],
+// repetitions with *, +, ?, {n} and {n,m}, alternations with | and groups with ().
+// Metacharacters are escaped with a backslash.
+// Perl extensions like \d, \w and flags are rejected.
+// Patterns are checked with [CheckPattern] before a query is executed.
+func Matches(field string, pattern string) Filter {
+	return Filter{Field: field, Operation: operations.Matches, Value: pattern}
+}
+
+// IMatches creates a case-insensitive filter
+// to find entities whose field value matches a regular expression.
+// Patterns have the same syntax as in [Matches].
+func IMatches(field string, pattern string) Filter {
+	return Filter{Field: field, Operation: operations.IMatches, Value: pattern}
+}
+
+// CheckPattern returns an error if a pattern of [Matches] or [IMatches]
+// doesn't have the supported syntax.
+// It's intended to be used by implementations of [Repo].
+func CheckPattern(pattern string) error {
+	if _, err := regexp.CompilePOSIX(pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
+
 // IPWithin creates a filter to find entities
 // whose field is an IP address contained within a given subnet.
 func IPWithin(field string, value string) Filter {
 	return Filter{Field: field, Operation: operations.IPWithin, Value: value}
 }
 
+// HasElement creates a filter to find entities
+// whose field is an array containing a given element.
+// Arrays are stored in native array columns in PostgreSQL and ClickHouse
+// and in columns with JSON arrays in MySQL and SQLite.
+func HasElement(field string, value any) Filter {
+	return Filter{Field: field, Operation: operations.HasElement, Value: value}
+}
+
+// HasAny creates a filter to find entities
+// whose field is an array containing any of given elements.
+// The elements can be passed in a slice of any type.
+func HasAny(field string, values any) Filter {
+	return Filter{Field: field, Operation: operations.HasAny, Value: values}
+}
+
+// HasAll creates a filter to find entities
+// whose field is an array containing all given elements.
+// The elements can be passed in a slice of any type.
+func HasAll(field string, values any) Filter {
+	return Filter{Field: field, Operation: operations.HasAll, Value: values}
+}
+
+// ArrayLen creates a filter to find entities
+// whose field is an array with a given number of elements.
+func ArrayLen(field string, length int) Filter {
+	return Filter{Field: field, Operation: operations.ArrayLen, Value: length}
+}
+
 // And creates a filter that joins multiple filters with the AND operator.
 func And(value ...Filter) Filter {
 	return Filter{Operation: operations.And, Value: value}
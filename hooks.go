@@ -0,0 +1,84 @@
+package hohin
+
+import (
+	"context"
+	"sync"
+)
+
+// AfterCommit registers a function executed after a transaction is committed.
+// If the DB stores transactions in contexts and the context contains its transaction,
+// the function is registered in that transaction, otherwise it's registered with [DB.AfterCommit].
+func AfterCommit(ctx context.Context, db DB, f func()) {
+	if tx, ok := TxFromContext(ctx, db); ok {
+		db = tx
+	}
+	db.AfterCommit(f)
+}
+
+// AfterRollback registers a function executed after a transaction is rolled back.
+// The transaction is found like in [AfterCommit].
+func AfterRollback(ctx context.Context, db DB, f func()) {
+	if tx, ok := TxFromContext(ctx, db); ok {
+		db = tx
+	}
+	db.AfterRollback(f)
+}
+
+// Hooks is a set of functions executed after a transaction is finished.
+// It's intended to be used by implementations of [DB].
+// A nil *Hooks belongs to a DB that isn't bound to a transaction,
+// so functions passed to its AfterCommit are executed immediately.
+type Hooks struct {
+	mutex         sync.Mutex
+	afterCommit   []func()
+	afterRollback []func()
+}
+
+// AfterCommit registers a function executed after the transaction is committed.
+func (h *Hooks) AfterCommit(f func()) {
+	if h == nil {
+		f()
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.afterCommit = append(h.afterCommit, f)
+}
+
+// AfterRollback registers a function executed after the transaction is rolled back.
+func (h *Hooks) AfterRollback(f func()) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.afterRollback = append(h.afterRollback, f)
+}
+
+// Finish executes registered functions when the outcome of the transaction is known.
+// If the transaction is nested into a transaction with parent hooks
+// and it's committed then its functions are moved to the parent hooks
+// because changes of the transaction can still be rolled back by the parent transaction.
+func (h *Hooks) Finish(parent *Hooks, committed bool) {
+	h.mutex.Lock()
+	afterCommit, afterRollback := h.afterCommit, h.afterRollback
+	h.afterCommit, h.afterRollback = nil, nil
+	h.mutex.Unlock()
+
+	if !committed {
+		for _, f := range afterRollback {
+			f()
+		}
+		return
+	}
+	if parent != nil {
+		parent.mutex.Lock()
+		defer parent.mutex.Unlock()
+		parent.afterCommit = append(parent.afterCommit, afterCommit...)
+		parent.afterRollback = append(parent.afterRollback, afterRollback...)
+		return
+	}
+	for _, f := range afterCommit {
+		f()
+	}
+}
@@ -1,5 +1,7 @@
 package hohin
 
+import "time"
+
 // IsolationLevel defines an isolation level of a database transaction.
 type IsolationLevel int
 
@@ -10,3 +12,21 @@ const (
 	RepeatableRead
 	Serializable
 )
+
+// TxOptions defines options of a database transaction.
+type TxOptions struct {
+	Isolation IsolationLevel // isolation level of a transaction
+	// if true then the transaction cannot change data
+	// and changes fail with an error matching ErrReadOnly
+	ReadOnly bool
+	// if true then a serializable read-only transaction waits until it can run without conflicts,
+	// it's supported only by PostgreSQL
+	Deferrable bool
+	// maximum duration of a transaction, it's rolled back when the duration is exceeded
+	Timeout time.Duration
+	// maximum duration of waiting for a lock held by another transaction
+	LockTimeout time.Duration
+	// if true then a panic of a transaction function is returned as a *PanicError,
+	// otherwise the transaction is rolled back and the panic is propagated
+	RecoverPanic bool
+}
@@ -0,0 +1,141 @@
+// Package keyset contains helpers for implementing keyset pagination in repositories.
+package keyset
+
+import (
+	"fmt"
+	"github.com/meowmeowcode/hohin"
+	"github.com/meowmeowcode/hohin/fields"
+	"reflect"
+)
+
+// Order returns an order extended with a key field used as a tie-breaker.
+// The key field is not added if it is empty or the order already contains it.
+func Order(order []hohin.Order, key string) []hohin.Order {
+	result := make([]hohin.Order, 0, len(order)+1)
+	result = append(result, order...)
+	if key == "" {
+		return result
+	}
+	for _, o := range order {
+		if o.Field == key {
+			return result
+		}
+	}
+	return append(result, hohin.Asc(key))
+}
+
+// Reverse returns an order with an opposite direction for every field.
+func Reverse(order []hohin.Order) []hohin.Order {
+	result := make([]hohin.Order, 0, len(order))
+	for _, o := range order {
+		result = append(result, hohin.Order{Field: o.Field, Desc: !o.Desc})
+	}
+	return result
+}
+
+// Values decodes a cursor into values of fields of T listed in an order.
+func Values[T any](c hohin.Cursor, order []hohin.Order) ([]any, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	pointers := make([]any, 0, len(order))
+	for _, o := range order {
+		fieldType, ok := fields.TypeByName(t, o.Field)
+		if !ok {
+			return nil, fmt.Errorf("unknown field `%s` in an order", o.Field)
+		}
+		pointers = append(pointers, reflect.New(fieldType).Interface())
+	}
+	if err := c.Decode(pointers...); err != nil {
+		return nil, err
+	}
+	values := make([]any, 0, len(pointers))
+	for _, p := range pointers {
+		values = append(values, reflect.ValueOf(p).Elem().Interface())
+	}
+	return values, nil
+}
+
+// Cursor returns a cursor pointing at a given entity in an order.
+func Cursor[T any](entity T, order []hohin.Order) (hohin.Cursor, error) {
+	v := reflect.ValueOf(entity)
+	values := make([]any, 0, len(order))
+	for _, o := range order {
+		field := fields.ByName(v, o.Field)
+		if !field.IsValid() {
+			return "", fmt.Errorf("unknown field `%s` in an order", o.Field)
+		}
+		values = append(values, field.Interface())
+	}
+	return hohin.NewCursor(values...)
+}
+
+// Query returns a query that must be executed to get entities for a page.
+// Its order contains a tie-breaker and is reversed when the page is requested backward.
+// Its fields include fields of the order because cursors are built from them.
+// Its limit is increased by one to find out whether there are more entities.
+func Query(q hohin.Query, key string) hohin.Query {
+	order := Order(q.Order, key)
+	if q.Backward && q.Cursor != "" {
+		order = Reverse(order)
+	}
+	result := hohin.Query{Filter: q.Filter, Order: order, Cursor: q.Cursor, Backward: q.Backward, Lock: q.Lock}
+	if len(q.Fields) > 0 {
+		result.Fields = withOrderFields(q.Fields, order)
+	}
+	if q.Limit > 0 {
+		result.Limit = q.Limit + 1
+	}
+	return result
+}
+
+// Page builds a page from entities retrieved with a query returned by [Query].
+// The original query is passed to find out the direction and the limit of the page.
+func Page[T any](entities []T, q hohin.Query, key string) (hohin.Page[T], error) {
+	backward := q.Backward && q.Cursor != ""
+	more := q.Limit > 0 && len(entities) > q.Limit
+	if more {
+		entities = entities[:q.Limit]
+	}
+	if backward {
+		for i, j := 0, len(entities)-1; i < j; i, j = i+1, j-1 {
+			entities[i], entities[j] = entities[j], entities[i]
+		}
+	}
+
+	page := hohin.Page[T]{Items: entities}
+	if len(entities) == 0 {
+		return page, nil
+	}
+
+	order := Order(q.Order, key)
+	if more || backward {
+		next, err := Cursor(entities[len(entities)-1], order)
+		if err != nil {
+			return page, err
+		}
+		page.Next = next
+	}
+	if (more && backward) || (!backward && q.Cursor != "") {
+		prev, err := Cursor(entities[0], order)
+		if err != nil {
+			return page, err
+		}
+		page.Prev = prev
+	}
+	return page, nil
+}
+
+func withOrderFields(names []string, order []hohin.Order) []string {
+	result := make([]string, 0, len(names)+len(order))
+	result = append(result, names...)
+	selected := make(map[string]bool)
+	for _, field := range names {
+		selected[field] = true
+	}
+	for _, o := range order {
+		if !selected[o.Field] {
+			result = append(result, o.Field)
+			selected[o.Field] = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,26 @@
+package hohin
+
+// LockStrength defines how entities loaded within a transaction are locked.
+type LockStrength int
+
+const (
+	NoLock    LockStrength = iota // entities are not locked
+	ForUpdate                     // entities are locked exclusively like with SELECT ... FOR UPDATE
+	ForShare                      // entities are protected from changes but can be locked for share by other transactions
+)
+
+// LockWait defines what happens when entities are already locked by another transaction.
+type LockWait int
+
+const (
+	Wait       LockWait = iota // wait until other transactions release their locks
+	NoWait                     // fail immediately
+	SkipLocked                 // skip locked entities
+)
+
+// Lock defines how entities loaded within a transaction are locked.
+// Databases that cannot lock entities return ErrUnsupported when a lock is requested.
+type Lock struct {
+	Strength LockStrength
+	Wait     LockWait
+}
@@ -2,43 +2,143 @@
 package mem
 
 import (
+	"bytes"
 	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/meowmeowcode/hohin"
+	"github.com/meowmeowcode/hohin/fields"
+	"github.com/meowmeowcode/hohin/keyset"
 	"github.com/meowmeowcode/hohin/operations"
 	"github.com/shopspring/decimal"
 	"net/netip"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // DB implements hohin.DB for an in-memory data structure.
 type DB struct {
-	data  map[string][][]byte
-	mutex sync.RWMutex
+	data     map[string][][]byte
+	mutex    sync.RWMutex
+	readOnly bool
+	hooks    *hohin.Hooks // hooks of a transaction the DB is bound to
+	ambient  *DB          // root DB that stores transactions in contexts, nil if they aren't stored
+	done     atomic.Bool  // defines if a transaction the DB is bound to is finished
 }
 
+// Transaction executes a given function with a snapshot of the data
+// that replaces the data only if the function succeeds.
+// Nested calls work the same way with a snapshot of the enclosing transaction.
 func (db *DB) Transaction(ctx context.Context, f func(context.Context, hohin.DB) error) error {
+	return db.TxWithOptions(ctx, hohin.TxOptions{}, f)
+}
+
+func (db *DB) Tx(ctx context.Context, _ hohin.IsolationLevel, f func(context.Context, hohin.DB) error) error {
+	return db.Transaction(ctx, f)
+}
+
+// TxWithOptions is similar to Transaction but allows to set options of the transaction.
+// Writes in a read-only transaction return hohin.ErrReadOnly,
+// the transaction fails if it isn't finished within the timeout,
+// and the isolation level and the lock timeout are ignored.
+func (db *DB) TxWithOptions(ctx context.Context, opts hohin.TxOptions, f func(context.Context, hohin.DB) error) error {
+	db = db.current(ctx)
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
 	db.mutex.Lock()
-	defer db.mutex.Unlock()
 	t := db.copy()
-	err := f(ctx, t)
+	t.readOnly = db.readOnly || opts.ReadOnly
+	t.hooks = &hohin.Hooks{}
+	t.ambient = db.ambient
+	if db.ambient != nil {
+		ctx = hohin.ContextWithTx(ctx, db.ambient, t)
+	}
+	err, panicked := hohin.CallTx(func() error {
+		return f(ctx, t)
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	t.mutex.Lock()
+	t.done.Store(true)
+	t.mutex.Unlock()
+	if err == nil {
+		db.data = t.data
+	}
+	// hooks are executed after the DB is unlocked because they can use it
+	db.mutex.Unlock()
 	if err != nil {
-		return err
+		t.hooks.Finish(db.hooks, false)
+		return hohin.Repanic(err, panicked, opts.RecoverPanic)
 	}
-	db.data = t.data
+	t.hooks.Finish(db.hooks, true)
 	return nil
 }
 
-func (db *DB) Tx(ctx context.Context, _ hohin.IsolationLevel, f func(context.Context, hohin.DB) error) error {
+// checkWritable returns hohin.ErrReadOnly if the DB is bound to a read-only transaction
+// and an error if the transaction is already finished.
+func (db *DB) checkWritable() error {
+	if db.readOnly {
+		return hohin.ErrReadOnly
+	}
+	if db.done.Load() {
+		return errors.New("transaction is already finished")
+	}
+	return nil
+}
+
+// TxRetry is the same as Transaction because transactions of an in-memory DB never conflict.
+func (db *DB) TxRetry(ctx context.Context, _ hohin.IsolationLevel, _ hohin.RetryPolicy, f func(context.Context, hohin.DB) error) error {
 	return db.Transaction(ctx, f)
 }
 
+// UseAmbientTx makes the DB store transactions in contexts passed to transaction functions.
+// Repository methods and transactions called with the DB and such a context
+// use the stored transaction instead of the DB, so the transactional DB
+// doesn't need to be passed through every call.
+// Goroutines spawned within a transaction can use it through the context,
+// and they cannot change data after the transaction is finished.
+// It returns the DB itself and must be called before the DB is used.
+func (db *DB) UseAmbientTx() *DB {
+	db.ambient = db
+	return db
+}
+
+// current returns a DB bound to an ambient transaction stored in a context
+// or the DB itself if there is no such transaction.
+func (db *DB) current(ctx context.Context) *DB {
+	if db.ambient != db {
+		return db
+	}
+	if tx, ok := hohin.TxFromContext(ctx, db); ok {
+		return tx.(*DB)
+	}
+	return db
+}
+
+// AfterCommit registers a function executed after a transaction the DB is bound to is committed.
+// The function is executed immediately if the DB isn't bound to a transaction,
+// so hohin.AfterCommit must be used to register it in a transaction stored in a context.
+func (db *DB) AfterCommit(f func()) {
+	db.hooks.AfterCommit(f)
+}
+
+// AfterRollback registers a function executed after a transaction the DB is bound to is rolled back.
+func (db *DB) AfterRollback(f func()) {
+	db.hooks.AfterRollback(f)
+}
+
 func (db *DB) Simple() hohin.SimpleDB {
 	return hohin.NewSimpleDB(db)
 }
@@ -60,13 +160,71 @@ func NewDB() *DB {
 }
 
 // Repo implements hohin.Repo for an in-memory data structure.
+// Values of a field with the "pk" tag option must be unique in a collection.
 type Repo[T any] struct {
-	collection string
+	collection   string
+	strictUpdate bool
+	key          string
+	pk           string
+	version      string
+	stored       []string
+	readOnly     map[string]bool
+}
+
+// Conf contains configuration of a [Repo].
+type Conf[T any] struct {
+	Collection string // name of a collection of entities
+	// if true then [Repo.Update] returns hohin.NotFound when no entities are updated
+	StrictUpdate bool
+	// field used to order entities with equal values of other fields in [Repo.GetPage],
+	// a field with the "pk" tag option or "Id" if an entity has it by default
+	Key string
+	// integer field used for optimistic locking, a field with the "version" tag option by default;
+	// [Repo.Update] increments it and returns hohin.ErrStaleEntity
+	// if a stored entity has another version
+	Version string
 }
 
-// NewRepo creates a [Repo].
+// NewRepo creates a [Repo] with a default configuration.
 func NewRepo[T any](collection string) *Repo[T] {
-	return &Repo[T]{collection: collection}
+	return NewRepoFromConf(Conf[T]{Collection: collection})
+}
+
+// NewRepoFromConf creates a [Repo] with a given configuration.
+func NewRepoFromConf[T any](conf Conf[T]) *Repo[T] {
+	if conf.Collection == "" {
+		panic("collection name is required to create a repository")
+	}
+	r := &Repo[T]{collection: conf.Collection, strictUpdate: conf.StrictUpdate}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	entityFields := fields.Of(t)
+	for _, f := range entityFields {
+		r.stored = append(r.stored, f.Name)
+	}
+	r.readOnly = fields.ReadOnly(entityFields)
+	r.pk = fields.Key(entityFields)
+
+	if conf.Key != "" {
+		r.key = conf.Key
+	} else if key := fields.Key(entityFields); key != "" {
+		r.key = key
+	} else if _, ok := t.FieldByName("Id"); ok {
+		r.key = "Id"
+	}
+
+	if conf.Version != "" {
+		r.version = conf.Version
+	} else {
+		r.version = fields.Version(entityFields)
+	}
+	if r.version != "" {
+		versionType, ok := fields.TypeByName(t, r.version)
+		if !ok || !fields.IsInteger(versionType) {
+			panic(fmt.Sprintf("version field `%s` must be an integer field of an entity", r.version))
+		}
+	}
+	return r
 }
 
 func (r *Repo[T]) Simple() hohin.SimpleRepo[T] {
@@ -75,7 +233,7 @@ func (r *Repo[T]) Simple() hohin.SimpleRepo[T] {
 
 func (r *Repo[T]) Get(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
 	var zero T
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	for _, record := range db.data[r.collection] {
@@ -94,12 +252,51 @@ func (r *Repo[T]) Get(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error
 	return zero, hohin.NotFound
 }
 
+// GetOne finds an entity and returns it like Get
+// but returns hohin.ErrMultipleFound if several entities match the filter.
+func (r *Repo[T]) GetOne(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
+	var zero T
+	entities, err := r.GetMany(ctx, d, hohin.Query{Filter: f, Limit: 2})
+	if err != nil {
+		return zero, err
+	}
+	switch len(entities) {
+	case 0:
+		return zero, hohin.NotFound
+	case 1:
+		return entities[0], nil
+	default:
+		return zero, hohin.ErrMultipleFound
+	}
+}
+
 func (r *Repo[T]) GetForUpdate(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
 	return r.Get(ctx, d, f)
 }
 
+// GetLocked finds an entity like Get.
+// Transactions of an in-memory DB are executed one by one,
+// so within a transaction a requested lock is always acquired without waiting.
+// Outside of a transaction reads wait until a running transaction is finished,
+// so a lock with hohin.NoWait or hohin.SkipLocked returns hohin.ErrUnsupported.
+func (r *Repo[T]) GetLocked(ctx context.Context, d hohin.DB, f hohin.Filter, l hohin.Lock) (T, error) {
+	if err := d.(*DB).current(ctx).checkLock(l); err != nil {
+		var zero T
+		return zero, err
+	}
+	return r.Get(ctx, d, f)
+}
+
+// checkLock returns hohin.ErrUnsupported if a lock cannot be emulated by the DB.
+func (db *DB) checkLock(l hohin.Lock) error {
+	if l.Strength != hohin.NoLock && l.Wait != hohin.Wait && db.hooks == nil {
+		return fmt.Errorf("%w: an in-memory DB cannot lock entities without waiting outside of a transaction", hohin.ErrUnsupported)
+	}
+	return nil
+}
+
 func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool, error) {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	for _, record := range db.data[r.collection] {
@@ -119,18 +316,26 @@ func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool,
 }
 
 func (r *Repo[T]) Delete(ctx context.Context, d hohin.DB, f hohin.Filter) error {
-	db := d.(*DB)
+	_, err := r.DeleteCount(ctx, d, f)
+	return err
+}
+
+func (r *Repo[T]) DeleteCount(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64, error) {
+	db := d.(*DB).current(ctx)
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
 	indices := make([]int, 0)
 	for i, record := range db.data[r.collection] {
 		entity, err := r.load(record)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		found, err := r.matchesFilter(entity, f)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if found {
 			indices = append(indices, i)
@@ -144,11 +349,11 @@ func (r *Repo[T]) Delete(ctx context.Context, d hohin.DB, f hohin.Filter) error
 		db.data[r.collection] = collection
 	}
 
-	return nil
+	return uint64(len(indices)), nil
 }
 
 func (r Repo[T]) Count(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64, error) {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	var result uint64
@@ -169,7 +374,10 @@ func (r Repo[T]) Count(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64,
 }
 
 func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T, error) {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
+	if err := db.checkLock(q.Lock); err != nil {
+		return nil, err
+	}
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	result := []T{}
@@ -188,96 +396,543 @@ func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T,
 	}
 
 	if len(q.Order) > 0 {
+		for _, o := range q.Order {
+			if !fields.ByName(reflect.ValueOf(new(T)).Elem(), o.Field).IsValid() {
+				return nil, fmt.Errorf("unknown field `%s` in an order", o.Field)
+			}
+		}
+		var sortErr error
 		sort.SliceStable(result, func(i, j int) bool {
-			v1 := reflect.ValueOf(result[i])
-			v2 := reflect.ValueOf(result[j])
-
+			values := make([]any, 0, len(q.Order))
+			v := reflect.ValueOf(result[j])
 			for _, o := range q.Order {
-				f1 := v1.FieldByName(o.Field)
-				f2 := v2.FieldByName(o.Field)
-				switch f1.Kind() {
-				case reflect.Int:
-					a, b := f1.Int(), f2.Int()
-					if (!o.Desc && a < b) || (o.Desc && a > b) {
-						return true
-					}
-				case reflect.Float64:
-					a, b := f1.Float(), f2.Float()
-					if (!o.Desc && a < b) || (o.Desc && a > b) {
-						return true
-					}
-				case reflect.String:
-					a, b := f1.String(), f2.String()
-					if (!o.Desc && a < b) || (o.Desc && a > b) {
-						return true
-					}
-				case reflect.Bool:
-					a, b := f1.Bool(), f2.Bool()
-					if (!o.Desc && !a && b) || (o.Desc && a && !b) {
-						return true
-					}
-				}
+				values = append(values, fields.ByName(v, o.Field).Interface())
 			}
-
-			return false
+			c, err := compareWithValues(result[i], q.Order, values)
+			if err != nil {
+				sortErr = err
+			}
+			return c < 0
 		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
 	}
 
-	if len(result) > 0 {
-		if q.Offset > 0 {
-			result = result[q.Offset:]
+	if q.Offset > 0 {
+		if q.Offset >= len(result) {
+			return []T{}, nil
 		}
-		if q.Limit > 0 {
-			result = result[:q.Limit]
+		result = result[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[:q.Limit]
+	}
+
+	if len(q.Fields) > 0 {
+		for i, entity := range result {
+			projected, err := project(entity, q.Fields)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = projected
 		}
 	}
 
 	return result, nil
 }
 
+// project returns a copy of an entity where all fields except given ones have zero values.
+func project[T any](entity T, names []string) (T, error) {
+	var result T
+	v := reflect.ValueOf(entity)
+	rv := reflect.ValueOf(&result).Elem()
+	for _, field := range names {
+		f := fields.ByName(v, field)
+		if !f.IsValid() {
+			return result, fmt.Errorf("unknown field `%s` in a projection", field)
+		}
+		fields.ByName(rv, field).Set(f)
+	}
+	return result, nil
+}
+
+func (r *Repo[T]) Each(ctx context.Context, d hohin.DB, q hohin.Query, f func(T) error) error {
+	if q.Cursor != "" {
+		return errors.New("cursors can be used only with GetPage")
+	}
+	if len(q.Order) > 0 {
+		entities, err := r.GetMany(ctx, d, q)
+		if err != nil {
+			return err
+		}
+		for _, entity := range entities {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := f(entity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	db := d.(*DB).current(ctx)
+	if err := db.checkLock(q.Lock); err != nil {
+		return err
+	}
+	db.mutex.RLock()
+	records := make([][]byte, len(db.data[r.collection]))
+	copy(records, db.data[r.collection])
+	db.mutex.RUnlock()
+
+	skipped, passed := 0, 0
+	for _, record := range records {
+		if q.Limit > 0 && passed >= q.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entity, err := r.load(record)
+		if err != nil {
+			return err
+		}
+		found, err := r.matchesFilter(entity, q.Filter)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		passed++
+		if len(q.Fields) > 0 {
+			entity, err = project(entity, q.Fields)
+			if err != nil {
+				return err
+			}
+		}
+		if err := f(entity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo[T]) GetPage(ctx context.Context, d hohin.DB, q hohin.Query) (hohin.Page[T], error) {
+	pq := keyset.Query(q, r.key)
+	var values []any
+	if pq.Cursor != "" {
+		var err error
+		values, err = keyset.Values[T](pq.Cursor, pq.Order)
+		if err != nil {
+			return hohin.Page[T]{}, err
+		}
+	}
+	entities, err := r.GetMany(ctx, d, hohin.Query{Filter: pq.Filter, Order: pq.Order})
+	if err != nil {
+		return hohin.Page[T]{}, err
+	}
+	result := []T{}
+	for _, entity := range entities {
+		if pq.Limit > 0 && len(result) == pq.Limit {
+			break
+		}
+		if values != nil {
+			c, err := compareWithValues(entity, pq.Order, values)
+			if err != nil {
+				return hohin.Page[T]{}, err
+			}
+			if c <= 0 {
+				continue
+			}
+		}
+		if len(pq.Fields) > 0 {
+			entity, err = project(entity, pq.Fields)
+			if err != nil {
+				return hohin.Page[T]{}, err
+			}
+		}
+		result = append(result, entity)
+	}
+	return keyset.Page(result, q, r.key)
+}
+
+// compareWithValues compares fields of an entity listed in an order with given values.
+func compareWithValues[T any](entity T, order []hohin.Order, values []any) (int, error) {
+	v := reflect.ValueOf(entity)
+	for i, o := range order {
+		c, err := compareValues(fields.ByName(v, o.Field), reflect.ValueOf(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		if o.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// compareValues returns -1, 0 or 1 if the first value is less than,
+// equal to or greater than the second one.
+func compareValues(a, b reflect.Value) (int, error) {
+	a, aIsNull := nullableValue(a)
+	b, bIsNull := nullableValue(b)
+	if aIsNull || bIsNull {
+		switch {
+		case aIsNull && bIsNull:
+			return 0, nil
+		case aIsNull:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+
+	switch x := a.Interface().(type) {
+	case time.Time:
+		return x.Compare(b.Interface().(time.Time)), nil
+	case decimal.Decimal:
+		return x.Cmp(b.Interface().(decimal.Decimal)), nil
+	case uuid.UUID:
+		y := b.Interface().(uuid.UUID)
+		return bytes.Compare(x[:], y[:]), nil
+	case netip.Addr:
+		return x.Compare(b.Interface().(netip.Addr)), nil
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int(), b.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(a.Uint(), b.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float(), b.Float()), nil
+	case reflect.String:
+		return strings.Compare(a.String(), b.String()), nil
+	case reflect.Bool:
+		x, y := a.Bool(), b.Bool()
+		if x == y {
+			return 0, nil
+		}
+		if y {
+			return -1, nil
+		}
+		return 1, nil
+	}
+
+	return 0, fmt.Errorf("cannot compare values of type %s", a.Type())
+}
+
+func compareOrdered[V int64 | uint64 | float64](a, b V) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
 func (r *Repo[T]) matchesFilter(entity T, f hohin.Filter) (bool, error) {
+	result, err := r.evalFilter(entity, f)
+	return result == truthTrue, err
+}
+
+// truth is a result of a filter in three-valued logic used by SQL,
+// where a comparison with NULL is neither true nor false.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+func (r *Repo[T]) evalFilter(entity T, f hohin.Filter) (truth, error) {
 	switch f.Operation {
 	case "":
-		return true, nil
+		return truthTrue, nil
 	case operations.Not:
-		result, err := r.matchesFilter(entity, f.Value.(hohin.Filter))
+		result, err := r.evalFilter(entity, f.Value.(hohin.Filter))
 		if err != nil {
-			return false, err
+			return truthFalse, err
+		}
+		switch result {
+		case truthTrue:
+			return truthFalse, nil
+		case truthFalse:
+			return truthTrue, nil
 		}
-		return !result, nil
+		return truthUnknown, nil
 	case operations.And:
+		total := truthTrue
 		for _, filter := range f.Value.([]hohin.Filter) {
-			result, err := r.matchesFilter(entity, filter)
+			result, err := r.evalFilter(entity, filter)
 			if err != nil {
-				return false, err
+				return truthFalse, err
 			}
-			if !result {
-				return false, nil
+			if result == truthFalse {
+				return truthFalse, nil
+			}
+			if result == truthUnknown {
+				total = truthUnknown
 			}
 		}
-		return true, nil
+		return total, nil
 	case operations.Or:
+		total := truthFalse
 		for _, filter := range f.Value.([]hohin.Filter) {
-			result, err := r.matchesFilter(entity, filter)
+			result, err := r.evalFilter(entity, filter)
 			if err != nil {
-				return false, err
+				return truthFalse, err
 			}
-			if result {
-				return true, nil
+			if result == truthTrue {
+				return truthTrue, nil
+			}
+			if result == truthUnknown {
+				total = truthUnknown
 			}
 		}
-		return false, nil
+		return total, nil
 	}
 
 	s := reflect.ValueOf(entity)
-	field := s.FieldByName(f.Field)
+	field := fields.ByName(s, f.Field)
 	if !field.IsValid() {
-		return false, fmt.Errorf("unknown field `%s` in a filter", f.Field)
+		return truthFalse, fmt.Errorf("unknown field `%s` in a filter", f.Field)
 	}
-
+	switch f.Operation {
+	case operations.HasElement, operations.HasAny, operations.HasAll, operations.ArrayLen:
+		return evalArray(field, f)
+	}
+	if ref, ok := f.Value.(hohin.FieldRef); ok {
+		switch f.Operation {
+		case operations.Eq, operations.IEq, operations.Ne, operations.INe,
+			operations.Lt, operations.Gt, operations.Lte, operations.Gte:
+		default:
+			return truthFalse, fmt.Errorf("operation %s is not supported for %T", f.Operation, ref)
+		}
+		other := fields.ByName(s, string(ref))
+		if !other.IsValid() {
+			return truthFalse, fmt.Errorf("unknown field `%s` in a filter", ref)
+		}
+		f.Value = other.Interface()
+	}
+	field, isNull := nullableValue(field)
 	switch f.Operation {
 	case operations.IsNull:
-		return field.IsNil(), nil
+		return truthOf(isNull), nil
+	case operations.IsNotNull:
+		return truthOf(!isNull), nil
+	}
+	if isNull {
+		return truthUnknown, nil
+	}
+	switch f.Operation {
+	case operations.In, operations.NotIn:
+		return evalIn(field, f)
+	case operations.Between:
+		bounds := f.Value.([]any)
+		return evalAll(field, hohin.Gte(f.Field, bounds[0]), hohin.Lte(f.Field, bounds[1]))
+	}
+	return evalAll(field, f)
+}
+
+// evalAll compares a non-null field with values of filters
+// and reports if all of them are satisfied.
+// A filter with a null value makes the result unknown unless another filter isn't satisfied.
+func evalAll(field reflect.Value, filters ...hohin.Filter) (truth, error) {
+	total := truthTrue
+	for _, f := range filters {
+		value, ok := filterValue(f.Value)
+		if !ok {
+			total = truthUnknown
+			continue
+		}
+		f.Value = value
+		result, err := matchesValue(field, f)
+		if err != nil {
+			return truthFalse, err
+		}
+		if !result {
+			return truthFalse, nil
+		}
+	}
+	return total, nil
+}
+
+// evalArray checks elements of a field that is a slice or an array.
+// The result is unknown if the field is a nil slice or a nil pointer.
+func evalArray(field reflect.Value, f hohin.Filter) (truth, error) {
+	for field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return truthUnknown, nil
+		}
+		field = field.Elem()
+	}
+	if field.Kind() != reflect.Slice && field.Kind() != reflect.Array {
+		return truthFalse, fmt.Errorf("operation %s is not supported for a field of %s", f.Operation, field.Type())
+	}
+	if field.Kind() == reflect.Slice && field.IsNil() {
+		return truthUnknown, nil
+	}
+	switch f.Operation {
+	case operations.HasElement:
+		found, err := hasElement(field, f.Value)
+		return truthOf(found), err
+	case operations.HasAny, operations.HasAll:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return truthFalse, fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		all := f.Operation == operations.HasAll
+		for _, item := range items {
+			found, err := hasElement(field, item)
+			if err != nil {
+				return truthFalse, err
+			}
+			if found != all {
+				return truthOf(found), nil
+			}
+		}
+		return truthOf(all), nil
+	default:
+		length, ok := f.Value.(int)
+		if !ok {
+			return truthFalse, fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		return truthOf(field.Len() == length), nil
+	}
+}
+
+// hasElement reports if a slice or an array contains a value.
+func hasElement(array reflect.Value, v any) (bool, error) {
+	value, ok := filterValue(v)
+	if !ok {
+		return false, nil
+	}
+	for i := 0; i < array.Len(); i++ {
+		elem, isNull := nullableValue(array.Index(i))
+		if isNull {
+			continue
+		}
+		found, err := matchesValue(elem, hohin.Eq("", value))
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// evalIn checks if a non-null field is within a slice of an In or NotIn filter.
+// Like in SQL, the result is unknown if the field isn't found in the slice containing nulls.
+func evalIn(field reflect.Value, f hohin.Filter) (truth, error) {
+	items, ok := hohin.Items(f.Value)
+	if !ok {
+		return truthFalse, fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+	}
+	found, hasNull := false, false
+	for _, item := range items {
+		value, ok := filterValue(item)
+		if !ok {
+			hasNull = true
+			continue
+		}
+		result, err := matchesValue(field, hohin.Eq(f.Field, value))
+		if err != nil {
+			return truthFalse, err
+		}
+		if result {
+			found = true
+			break
+		}
+	}
+	switch {
+	case found:
+		return truthOf(f.Operation == operations.In), nil
+	case hasNull:
+		return truthUnknown, nil
+	default:
+		return truthOf(f.Operation == operations.NotIn), nil
+	}
+}
+
+// filterValue prepares a value of a filter for comparison with a field.
+// It reports false if the value is null.
+func filterValue(v any) (any, bool) {
+	if v == nil {
+		return nil, false
+	}
+	value, isNull := nullableValue(reflect.ValueOf(v))
+	if isNull {
+		return nil, false
+	}
+	return normalizeNumber(value).Interface(), true
+}
+
+var (
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	decimalType = reflect.TypeOf(decimal.Decimal{})
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+)
+
+// nullableValue dereferences pointers and extracts values of types implementing driver.Valuer,
+// like sql.NullString. It reports if a value is NULL.
+// Values of decimal.Decimal and uuid.UUID are returned as is.
+func nullableValue(v reflect.Value) (reflect.Value, bool) {
+	for {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface:
+			if v.IsNil() {
+				return v, true
+			}
+			v = v.Elem()
+			continue
+		case reflect.Map, reflect.Slice:
+			if v.IsNil() {
+				return v, true
+			}
+		}
+		if v.Type() == decimalType || v.Type() == uuidType || !v.Type().Implements(valuerType) {
+			return v, false
+		}
+		value, err := v.Interface().(driver.Valuer).Value()
+		if err != nil || value == nil {
+			return v, value == nil
+		}
+		return reflect.ValueOf(value), false
+	}
+}
+
+// normalizeNumber converts integers to int and floats to float64
+// because filters compare fields only with values of these types.
+func normalizeNumber(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.ValueOf(int(v.Int()))
+	case reflect.Float32:
+		return reflect.ValueOf(v.Float())
+	}
+	return v
+}
+
+func matchesValue(field reflect.Value, f hohin.Filter) (bool, error) {
+	switch f.Operation {
 	case operations.Eq:
 		switch val := f.Value.(type) {
 		case int:
@@ -424,6 +1079,34 @@ func (r *Repo[T]) matchesFilter(entity T, f hohin.Filter) (bool, error) {
 		default:
 			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
 		}
+	case operations.Like:
+		switch val := f.Value.(type) {
+		case string:
+			return likePattern(val, false).MatchString(field.String()), nil
+		default:
+			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+		}
+	case operations.ILike:
+		switch val := f.Value.(type) {
+		case string:
+			return likePattern(val, true).MatchString(field.String()), nil
+		default:
+			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+		}
+	case operations.Matches:
+		switch val := f.Value.(type) {
+		case string:
+			return matchesPattern(field.String(), val, false)
+		default:
+			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+		}
+	case operations.IMatches:
+		switch val := f.Value.(type) {
+		case string:
+			return matchesPattern(field.String(), val, true)
+		default:
+			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+		}
 	case operations.IPWithin:
 		switch val := f.Value.(type) {
 		case string:
@@ -439,44 +1122,48 @@ func (r *Repo[T]) matchesFilter(entity T, f hohin.Filter) (bool, error) {
 		default:
 			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
 		}
-	case operations.In:
-		switch val := f.Value.(type) {
-		case []any:
-			for _, item := range val {
-				switch x := item.(type) {
-				case string:
-					if x == field.String() {
-						return true, nil
-					}
-				case int:
-					if int64(x) == field.Int() {
-						return true, nil
-					}
-				case int32:
-					if int64(x) == field.Int() {
-						return true, nil
-					}
-				case int64:
-					if int64(x) == field.Int() {
-						return true, nil
-					}
-				case float32:
-					if float64(x) == field.Float() {
-						return true, nil
-					}
-				case float64:
-					if x == field.Float() {
-						return true, nil
-					}
-				}
-			}
-			return false, nil
+	}
+
+	return false, fmt.Errorf("unknown operation %s", f.Operation)
+}
+
+// likePattern converts a pattern of hohin.Like to a regular expression.
+func likePattern(pattern string, insensitive bool) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)")
+	if insensitive {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
 		default:
-			return false, fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+			b.WriteString(regexp.QuoteMeta(string(c)))
 		}
 	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
 
-	return false, fmt.Errorf("unknown operation %s", f.Operation)
+// matchesPattern reports if a value matches a pattern of hohin.Matches or hohin.IMatches.
+func matchesPattern(value, pattern string, insensitive bool) (bool, error) {
+	if err := hohin.CheckPattern(pattern); err != nil {
+		return false, err
+	}
+	if insensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.MustCompile(pattern).MatchString(value), nil
 }
 
 func (r *Repo[T]) GetFirst(ctx context.Context, d hohin.DB, q hohin.Query) (T, error) {
@@ -493,10 +1180,16 @@ func (r *Repo[T]) GetFirst(ctx context.Context, d hohin.DB, q hohin.Query) (T, e
 }
 
 func (r *Repo[T]) Add(ctx context.Context, d hohin.DB, entity T) error {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
 	records := db.data[r.collection]
+	if err := r.checkUnique(records, entity); err != nil {
+		return err
+	}
 	record, err := r.dump(entity)
 	if err != nil {
 		return err
@@ -505,6 +1198,32 @@ func (r *Repo[T]) Add(ctx context.Context, d hohin.DB, entity T) error {
 	return nil
 }
 
+// checkUnique returns hohin.ErrUniqueViolation
+// if the field with the "pk" tag option of a new entity repeats a stored value.
+func (r *Repo[T]) checkUnique(records [][]byte, entity T) error {
+	if r.pk == "" {
+		return nil
+	}
+	for _, record := range records {
+		e, err := r.load(record)
+		if err != nil {
+			return err
+		}
+		same, err := r.sameFields(e, entity, []string{r.pk})
+		if err != nil {
+			return err
+		}
+		if same {
+			return &hohin.DBError{
+				Kind:   hohin.ErrUniqueViolation,
+				Column: r.pk,
+				Err:    fmt.Errorf("duplicate value of field `%s` in collection `%s`", r.pk, r.collection),
+			}
+		}
+	}
+	return nil
+}
+
 func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 	for _, e := range entities {
 		if err := r.Add(ctx, d, e); err != nil {
@@ -514,54 +1233,415 @@ func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 	return nil
 }
 
-func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) error {
-	db := d.(*DB)
+func (r *Repo[T]) Upsert(ctx context.Context, d hohin.DB, entity T, conflictFields ...string) error {
+	if len(conflictFields) == 0 {
+		return errors.New("conflict fields are required for upsert")
+	}
+	db := d.(*DB).current(ctx)
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
-	index := -1
-	for i, record := range db.data[r.collection] {
-		entity, err := r.load(record)
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	record, err := r.dump(entity)
+	if err != nil {
+		return err
+	}
+	for i, rec := range db.data[r.collection] {
+		e, err := r.load(rec)
 		if err != nil {
 			return err
 		}
-		found, err := r.matchesFilter(entity, f)
+		same, err := r.sameFields(e, entity, conflictFields)
 		if err != nil {
 			return err
 		}
+		if same {
+			newEntity := entity
+			r.keepReadOnly(&newEntity, e)
+			newRecord, err := r.dump(newEntity)
+			if err != nil {
+				return err
+			}
+			db.data[r.collection][i] = newRecord
+			return nil
+		}
+	}
+	if err := r.checkUnique(db.data[r.collection], entity); err != nil {
+		return err
+	}
+	db.data[r.collection] = append(db.data[r.collection], record)
+	return nil
+}
+
+func (r *Repo[T]) UpsertMany(ctx context.Context, d hohin.DB, entities []T, conflictFields ...string) error {
+	for _, e := range entities {
+		if err := r.Upsert(ctx, d, e, conflictFields...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo[T]) sameFields(e1, e2 T, names []string) (bool, error) {
+	v1 := reflect.ValueOf(e1)
+	v2 := reflect.ValueOf(e2)
+	for _, f := range names {
+		f1 := fields.ByName(v1, f)
+		if !f1.IsValid() {
+			return false, fmt.Errorf("unknown field `%s` in conflict fields", f)
+		}
+		j1, err := json.Marshal(f1.Interface())
+		if err != nil {
+			return false, err
+		}
+		j2, err := json.Marshal(fields.ByName(v2, f).Interface())
+		if err != nil {
+			return false, err
+		}
+		if string(j1) != string(j2) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) error {
+	count, err := r.UpdateCount(ctx, d, f, entity)
+	if err != nil {
+		return err
+	}
+	if r.version != "" && count == 0 {
+		return hohin.ErrStaleEntity
+	}
+	if r.strictUpdate && count == 0 {
+		return hohin.NotFound
+	}
+	return nil
+}
+
+func (r *Repo[T]) UpdateCount(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) (uint64, error) {
+	db := d.(*DB).current(ctx)
+	f = r.versioned(f, &entity)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
+	updated := make(map[int][]byte)
+	for i, record := range db.data[r.collection] {
+		old, err := r.load(record)
+		if err != nil {
+			return 0, err
+		}
+		found, err := r.matchesFilter(old, f)
+		if err != nil {
+			return 0, err
+		}
 		if found {
-			index = i
-			break
+			newEntity := entity
+			r.keepReadOnly(&newEntity, old)
+			newRecord, err := r.dump(newEntity)
+			if err != nil {
+				return 0, err
+			}
+			updated[i] = newRecord
 		}
 	}
 
-	if index > -1 {
+	for i, record := range updated {
+		db.data[r.collection][i] = record
+	}
+
+	return uint64(len(updated)), nil
+}
+
+// versioned returns a filter that also matches the current version of an entity
+// and increments the version of the entity if the repository has a version field.
+func (r *Repo[T]) versioned(f hohin.Filter, entity *T) hohin.Filter {
+	if r.version == "" {
+		return f
+	}
+	version := fields.ByName(reflect.ValueOf(entity).Elem(), r.version)
+	versionFilter := hohin.Eq(r.version, version.Interface())
+	fields.Increment(version)
+	if f.Operation == "" {
+		return versionFilter
+	}
+	return hohin.And(f, versionFilter)
+}
+
+// keepReadOnly copies values of read-only fields from an old entity to a new one.
+func (r *Repo[T]) keepReadOnly(entity *T, old T) {
+	v := reflect.ValueOf(entity).Elem()
+	ov := reflect.ValueOf(old)
+	for field := range r.readOnly {
+		fields.ByName(v, field).Set(fields.ByName(ov, field))
+	}
+}
+
+func (r *Repo[T]) UpdateFields(ctx context.Context, d hohin.DB, f hohin.Filter, set hohin.Set) (uint64, error) {
+	if len(set) == 0 {
+		return 0, errors.New("nothing to update")
+	}
+	db := d.(*DB).current(ctx)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return 0, err
+	}
+	var count uint64
+	for i, record := range db.data[r.collection] {
+		entity, err := r.load(record)
+		if err != nil {
+			return 0, err
+		}
+		found, err := r.matchesFilter(entity, f)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			continue
+		}
+		if err := r.applySet(&entity, set); err != nil {
+			return 0, err
+		}
 		record, err := r.dump(entity)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		db.data[r.collection][index] = record
+		db.data[r.collection][i] = record
+		count += 1
 	}
+	return count, nil
+}
+
+func (r *Repo[T]) applySet(entity *T, set hohin.Set) error {
+	v := reflect.ValueOf(entity).Elem()
+	for _, a := range set {
+		field := fields.ByName(v, a.Field)
+		if !field.IsValid() {
+			return fmt.Errorf("unknown field `%s` in a set", a.Field)
+		}
+		switch a.Operation {
+		case operations.Assign:
+			value, err := convertValue(a.Value, field.Type())
+			if err != nil {
+				return fmt.Errorf("cannot assign a value to `%s`: %w", a.Field, err)
+			}
+			field.Set(value)
+		case operations.Incr:
+			if err := increment(field, a.Value); err != nil {
+				return fmt.Errorf("cannot increment `%s`: %w", a.Field, err)
+			}
+		case operations.SetNull:
+			field.Set(reflect.Zero(field.Type()))
+		default:
+			return fmt.Errorf("operation %s is not supported", a.Operation)
+		}
+	}
+	return nil
+}
+
+func convertValue(value any, t reflect.Type) (reflect.Value, error) {
+	v := reflect.ValueOf(value)
+	switch {
+	case !v.IsValid():
+		return reflect.Zero(t), nil
+	case v.Type().AssignableTo(t):
+		return v, nil
+	case t.Kind() == reflect.Pointer:
+		elem, err := convertValue(value, t.Elem())
+		if err != nil {
+			return elem, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, nil
+	case v.Kind() != reflect.String && v.CanConvert(t):
+		return v.Convert(t), nil
+	}
+	return v, fmt.Errorf("%T is not convertible to %s", value, t)
+}
 
+func increment(field reflect.Value, value any) error {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return nil
+		}
+		field = field.Elem()
+	}
+	delta, err := toDecimal(value)
+	if err != nil {
+		return err
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(decimal.NewFromInt(field.Int()).Add(delta).IntPart())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(uint64(decimal.NewFromInt(int64(field.Uint())).Add(delta).IntPart()))
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(decimal.NewFromFloat(field.Float()).Add(delta).InexactFloat64())
+	default:
+		d, ok := field.Interface().(decimal.Decimal)
+		if !ok {
+			return fmt.Errorf("%s is not a number", field.Type())
+		}
+		field.Set(reflect.ValueOf(d.Add(delta)))
+	}
 	return nil
 }
 
+func toDecimal(value any) (decimal.Decimal, error) {
+	if d, ok := value.(decimal.Decimal); ok {
+		return d, nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decimal.NewFromInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return decimal.NewFromInt(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return decimal.NewFromFloat(v.Float()), nil
+	}
+	return decimal.Zero, fmt.Errorf("%T is not a number", value)
+}
+
 func (r *Repo[T]) CountAll(ctx context.Context, d hohin.DB) (uint64, error) {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	return uint64(len(db.data[r.collection])), nil
 }
 
+// Sum returns a sum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Sum(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	groups, err := r.Aggregate(ctx, d, hohin.Aggregation{Field: field, Filter: f})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return groups[0].Sum, nil
+}
+
+// Avg returns an average of values of a field of entities matching a given filter.
+func (r *Repo[T]) Avg(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	groups, err := r.Aggregate(ctx, d, hohin.Aggregation{Field: field, Filter: f})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return groups[0].Avg, nil
+}
+
+// Min returns a minimum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Min(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	groups, err := r.Aggregate(ctx, d, hohin.Aggregation{Field: field, Filter: f})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return groups[0].Min, nil
+}
+
+// Max returns a maximum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Max(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	groups, err := r.Aggregate(ctx, d, hohin.Aggregation{Field: field, Filter: f})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return groups[0].Max, nil
+}
+
+func (r *Repo[T]) Aggregate(ctx context.Context, d hohin.DB, a hohin.Aggregation) ([]hohin.Group, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if _, ok := fields.TypeByName(t, a.Field); !ok {
+		return nil, fmt.Errorf("unknown field `%s` in an aggregation", a.Field)
+	}
+	order := make([]hohin.Order, 0, len(a.GroupBy))
+	for _, field := range a.GroupBy {
+		if _, ok := fields.TypeByName(t, field); !ok {
+			return nil, fmt.Errorf("unknown field `%s` in a grouping", field)
+		}
+		order = append(order, hohin.Asc(field))
+	}
+
+	entities, err := r.GetMany(ctx, d, hohin.Query{Filter: a.Filter, Order: order})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]hohin.Group, 0)
+	// numbers of non-null values in groups
+	counts := make([]int64, 0)
+	if len(a.GroupBy) == 0 {
+		result = append(result, hohin.Group{Key: []any{}})
+		counts = append(counts, 0)
+	}
+	var key []any
+	for i, entity := range entities {
+		v := reflect.ValueOf(entity)
+		if len(a.GroupBy) > 0 {
+			c := 1
+			if i > 0 {
+				c, err = compareWithValues(entity, order, key)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if c != 0 {
+				key = make([]any, 0, len(a.GroupBy))
+				for _, field := range a.GroupBy {
+					key = append(key, fields.ByName(v, field).Interface())
+				}
+				result = append(result, hohin.Group{Key: key})
+				counts = append(counts, 0)
+			}
+		}
+
+		group := &result[len(result)-1]
+		group.Count++
+		field, isNull := nullableValue(fields.ByName(v, a.Field))
+		if isNull {
+			continue
+		}
+		value, err := toDecimal(field.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if counts[len(result)-1] == 0 || value.LessThan(group.Min) {
+			group.Min = value
+		}
+		if counts[len(result)-1] == 0 || value.GreaterThan(group.Max) {
+			group.Max = value
+		}
+		counts[len(result)-1]++
+		group.Sum = group.Sum.Add(value)
+	}
+
+	for i := range result {
+		if counts[i] > 0 {
+			result[i].Avg = result[i].Sum.Div(decimal.NewFromInt(counts[i]))
+		}
+	}
+	return result, nil
+}
+
 func (r *Repo[T]) Clear(ctx context.Context, d hohin.DB) error {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
 	db.data[r.collection] = nil
 	return nil
 }
 
 func (r *Repo[T]) dump(entity T) ([]byte, error) {
-	return json.Marshal(entity)
+	stored, err := project(entity, r.stored)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stored)
 }
 
 func (r *Repo[T]) load(record []byte) (T, error) {
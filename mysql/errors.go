@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/meowmeowcode/hohin"
+	"regexp"
+)
+
+// kinds maps MySQL error numbers to kinds of hohin errors.
+var kinds = map[uint16]error{
+	1062: hohin.ErrUniqueViolation,
+	1216: hohin.ErrForeignKeyViolation,
+	1217: hohin.ErrForeignKeyViolation,
+	1451: hohin.ErrForeignKeyViolation,
+	1452: hohin.ErrForeignKeyViolation,
+	3819: hohin.ErrCheckViolation,
+	1048: hohin.ErrNotNullViolation,
+	1364: hohin.ErrNotNullViolation,
+	1213: hohin.ErrDeadlock,
+	1242: hohin.ErrTooManyResults,
+	1792: hohin.ErrReadOnly,
+}
+
+var (
+	keyPattern        = regexp.MustCompile("for key '([^']*)'")
+	constraintPattern = regexp.MustCompile("(?:CONSTRAINT `|constraint ')([^`']*)")
+	columnPattern     = regexp.MustCompile("^(?:Column|Field) '([^']*)'")
+)
+
+// classify wraps a MySQL error into a hohin.DBError if its kind is known.
+// Names of constraints and columns are parsed from an error message.
+func classify(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+	kind, ok := kinds[mysqlErr.Number]
+	if !ok {
+		return err
+	}
+	result := &hohin.DBError{Kind: kind, Err: err}
+	if m := keyPattern.FindStringSubmatch(mysqlErr.Message); m != nil {
+		result.Constraint = m[1]
+	} else if m := constraintPattern.FindStringSubmatch(mysqlErr.Message); m != nil {
+		result.Constraint = m[1]
+	}
+	if m := columnPattern.FindStringSubmatch(mysqlErr.Message); m != nil {
+		result.Column = m[1]
+	}
+	return result
+}
+
+// queryError returns an error of a failed query.
+func queryError(query string, err error) error {
+	return fmt.Errorf("cannot execute query `%s`: %w", query, classify(err))
+}
@@ -1,8 +1,9 @@
 // Package operations contains types of operations
-// that can be used for filtering entities.
+// that can be used for filtering and updating entities.
 package operations
 
-// Operation defines how a hohin.Filter must compare values.
+// Operation defines how a hohin.Filter must compare values
+// or how a hohin.Assignment must change a field.
 type Operation string
 
 const (
@@ -11,19 +12,36 @@ const (
 	Ne         Operation = "!="         // not equal
 	INe        Operation = "INe"        // not equal (case-insensitive)
 	IsNull     Operation = "IsNull"     // is null
+	IsNotNull  Operation = "IsNotNull"  // is not null
 	Lt         Operation = "<"          // less than
 	Gt         Operation = ">"          // greater than
 	Lte        Operation = "<="         // less than or equal
 	Gte        Operation = ">="         // greater than or equal
+	Between    Operation = "Between"    // within a range including its bounds
 	In         Operation = "In"         // in
+	NotIn      Operation = "NotIn"      // not in
 	Contains   Operation = "Contains"   // contains
 	IContains  Operation = "IContains"  // contains (case-insensitive)
 	HasPrefix  Operation = "HasPrefix"  // has prefix
 	IHasPrefix Operation = "IHasPrefix" // has prefix (case-insensitive)
 	HasSuffix  Operation = "HasSuffix"  // has suffix
 	IHasSuffix Operation = "IHasSuffix" // has suffix (case insensitive)
+	Like       Operation = "Like"       // matches a LIKE pattern
+	ILike      Operation = "ILike"      // matches a LIKE pattern (case-insensitive)
+	Matches    Operation = "Matches"    // matches a regular expression
+	IMatches   Operation = "IMatches"   // matches a regular expression (case-insensitive)
 	IPWithin   Operation = "IPWithin"   // an IP address is within a subnet
+	HasElement Operation = "HasElement" // an array contains an element
+	HasAny     Operation = "HasAny"     // an array contains any of elements
+	HasAll     Operation = "HasAll"     // an array contains all elements
+	ArrayLen   Operation = "ArrayLen"   // an array has a length
 	And        Operation = "And"        // all conditions are satisfied
 	Or         Operation = "Or"         // any condition is satisfied
 	Not        Operation = "Not"        // none of conditions is satisfied
 )
+
+const (
+	Assign  Operation = "Assign"  // set a field to a value
+	Incr    Operation = "Incr"    // increase a field by a value
+	SetNull Operation = "SetNull" // set a field to null
+)
@@ -0,0 +1,38 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/meowmeowcode/hohin"
+)
+
+// kinds maps SQLSTATE codes to kinds of hohin errors.
+var kinds = map[string]error{
+	"23505": hohin.ErrUniqueViolation,
+	"23503": hohin.ErrForeignKeyViolation,
+	"23514": hohin.ErrCheckViolation,
+	"23502": hohin.ErrNotNullViolation,
+	"40001": hohin.ErrSerialization,
+	"40P01": hohin.ErrDeadlock,
+	"21000": hohin.ErrTooManyResults,
+	"25006": hohin.ErrReadOnly,
+}
+
+// classify wraps a PostgreSQL error into a hohin.DBError if its kind is known.
+func classify(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	kind, ok := kinds[pgErr.Code]
+	if !ok {
+		return err
+	}
+	return &hohin.DBError{Kind: kind, Constraint: pgErr.ConstraintName, Column: pgErr.ColumnName, Err: err}
+}
+
+// queryError returns an error of a failed query.
+func queryError(query string, err error) error {
+	return fmt.Errorf("cannot execute query `%s`: %w", query, classify(err))
+}
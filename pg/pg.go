@@ -10,11 +10,16 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/meowmeowcode/hohin"
+	"github.com/meowmeowcode/hohin/fields"
+	"github.com/meowmeowcode/hohin/keyset"
 	"github.com/meowmeowcode/hohin/maps"
 	"github.com/meowmeowcode/hohin/operations"
 	"github.com/meowmeowcode/hohin/sqldb"
+	"github.com/shopspring/decimal"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 type executor interface {
@@ -27,38 +32,237 @@ type executor interface {
 // DB implements hohin.DB for PostgreSQL.
 type DB struct {
 	executor executor
+	hooks    *hohin.Hooks // hooks of a transaction the DB is bound to
+	ambient  *DB          // root DB that stores transactions in contexts, nil if they aren't stored
+	mutex    *sync.Mutex  // serializes queries of a transaction the DB is bound to, nil if it isn't bound to one
 }
 
+// Transaction executes a given function within a transaction.
+// When it's called on a DB bound to a transaction, it creates a savepoint
+// and an error of the function rolls back only to that savepoint.
 func (db *DB) Transaction(ctx context.Context, f func(context.Context, hohin.DB) error) error {
 	return db.Tx(ctx, hohin.DefaultIsolation, f)
 }
 
+// Tx is similar to Transaction but requires to choose an isolation level.
+// When it's called on a DB bound to a transaction, it creates a savepoint
+// and the isolation level of the outer transaction is kept.
 func (db *DB) Tx(ctx context.Context, level hohin.IsolationLevel, f func(context.Context, hohin.DB) error) error {
-	executor, ok := db.executor.(*pgxpool.Pool)
-	if !ok {
-		panic("nested transactions are not supported")
-	}
-	txOptions := pgx.TxOptions{}
-	switch level {
-	case hohin.ReadUncommitted:
-		txOptions.IsoLevel = pgx.ReadUncommitted
-	case hohin.ReadCommitted:
-		txOptions.IsoLevel = pgx.ReadCommitted
-	case hohin.RepeatableRead:
-		txOptions.IsoLevel = pgx.RepeatableRead
-	case hohin.Serializable:
-		txOptions.IsoLevel = pgx.Serializable
-	}
-	tx, err := executor.BeginTx(ctx, txOptions)
+	return db.TxWithOptions(ctx, hohin.TxOptions{Isolation: level}, f)
+}
+
+// TxWithOptions is similar to Transaction but allows to set options of the transaction.
+// When it's called on a DB bound to a transaction, it creates a savepoint
+// and only the timeout and the lock timeout are applied.
+func (db *DB) TxWithOptions(ctx context.Context, opts hohin.TxOptions, f func(context.Context, hohin.DB) error) error {
+	db = db.current(ctx)
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	var tx pgx.Tx
+	var err error
+	switch executor := db.executor.(type) {
+	case pgx.Tx:
+		unlock := db.lock()
+		tx, err = executor.Begin(ctx)
+		unlock()
+	case *pgxpool.Pool:
+		txOptions := pgx.TxOptions{}
+		switch opts.Isolation {
+		case hohin.ReadUncommitted:
+			txOptions.IsoLevel = pgx.ReadUncommitted
+		case hohin.ReadCommitted:
+			txOptions.IsoLevel = pgx.ReadCommitted
+		case hohin.RepeatableRead:
+			txOptions.IsoLevel = pgx.RepeatableRead
+		case hohin.Serializable:
+			txOptions.IsoLevel = pgx.Serializable
+		}
+		if opts.ReadOnly {
+			txOptions.AccessMode = pgx.ReadOnly
+		}
+		if opts.Deferrable {
+			txOptions.DeferrableMode = pgx.Deferrable
+		}
+		tx, err = executor.BeginTx(ctx, txOptions)
+	default:
+		return errors.New("transactions are not supported by the executor")
+	}
 	if err != nil {
 		return err
 	}
-	err = f(ctx, &DB{executor: tx})
+	hooks := &hohin.Hooks{}
+	txDB := &DB{executor: tx, hooks: hooks, ambient: db.ambient, mutex: db.mutex}
+	if txDB.mutex == nil {
+		txDB.mutex = &sync.Mutex{}
+	}
+	if opts.LockTimeout > 0 {
+		// PostgreSQL measures the timeout in milliseconds and 0 disables it, so it's rounded up.
+		milliseconds := (opts.LockTimeout + time.Millisecond - 1) / time.Millisecond
+		query := fmt.Sprintf("SET LOCAL lock_timeout = %d", milliseconds)
+		if _, err := txDB.exec(ctx, query); err != nil {
+			return txDB.rollback(ctx, tx, queryError(query, err))
+		}
+	}
+	if db.ambient != nil {
+		ctx = hohin.ContextWithTx(ctx, db.ambient, txDB)
+	}
+	err, panicked := hohin.CallTx(func() error {
+		return f(ctx, txDB)
+	})
 	if err != nil {
-		tx.Rollback(ctx)
-		return err
+		err = txDB.rollback(ctx, tx, err)
+		hooks.Finish(db.hooks, false)
+		return hohin.Repanic(err, panicked, opts.RecoverPanic)
+	}
+	unlock := txDB.lock()
+	err = classify(tx.Commit(ctx))
+	unlock()
+	hooks.Finish(db.hooks, err == nil)
+	return err
+}
+
+// rollback rolls back a transaction the DB is bound to that failed with a given error
+// and joins the error with an error of the rollback.
+func (db *DB) rollback(ctx context.Context, tx pgx.Tx, err error) error {
+	defer db.lock()()
+	if rollbackErr := tx.Rollback(ctx); rollbackErr != nil && !errors.Is(rollbackErr, pgx.ErrTxClosed) {
+		return errors.Join(err, fmt.Errorf("cannot roll back a transaction: %w", rollbackErr))
+	}
+	return err
+}
+
+// TxRetry is similar to Tx but executes the function again in a new transaction
+// when the transaction fails because of a serialization failure or a deadlock.
+func (db *DB) TxRetry(ctx context.Context, level hohin.IsolationLevel, policy hohin.RetryPolicy, f func(context.Context, hohin.DB) error) error {
+	db = db.current(ctx)
+	if _, ok := db.executor.(pgx.Tx); ok {
+		return db.Tx(ctx, level, f)
+	}
+	return hohin.Retry(ctx, policy, isRetryable, func() error {
+		return db.Tx(ctx, level, f)
+	})
+}
+
+// isRetryable checks if an error is caused by a serialization failure or a deadlock.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// UseAmbientTx makes the DB store transactions in contexts passed to transaction functions.
+// Repository methods and transactions called with the DB and such a context
+// use the stored transaction instead of the DB, so the transactional DB
+// doesn't need to be passed through every call.
+// Goroutines spawned within a transaction can use it through the context
+// and their queries are executed one by one.
+// A transaction stays locked while rows of a query are read,
+// so a function passed to Each must not execute queries within the same transaction.
+// It returns the DB itself and must be called before the DB is used.
+func (db *DB) UseAmbientTx() *DB {
+	db.ambient = db
+	return db
+}
+
+// current returns a DB bound to an ambient transaction stored in a context
+// or the DB itself if there is no such transaction.
+func (db *DB) current(ctx context.Context) *DB {
+	if db.ambient != db {
+		return db
+	}
+	if tx, ok := hohin.TxFromContext(ctx, db); ok {
+		return tx.(*DB)
+	}
+	return db
+}
+
+// lock locks a transaction the DB is bound to until the returned function is called,
+// so that goroutines sharing the transaction execute queries one by one.
+func (db *DB) lock() func() {
+	if db.mutex == nil {
+		return func() {}
+	}
+	db.mutex.Lock()
+	return db.mutex.Unlock
+}
+
+// exec executes a query that doesn't return rows.
+func (db *DB) exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	defer db.lock()()
+	return db.executor.Exec(ctx, query, args...)
+}
+
+// query executes a query that returns rows
+// and keeps a transaction the DB is bound to locked until the rows are closed.
+func (db *DB) query(ctx context.Context, query string, args ...any) (*lockedRows, error) {
+	unlock := db.lock()
+	rows, err := db.executor.Query(ctx, query, args...)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &lockedRows{Rows: rows, unlock: unlock}, nil
+}
+
+// queryRow executes a query that returns at most one row
+// and keeps a transaction the DB is bound to locked until the row is scanned.
+func (db *DB) queryRow(ctx context.Context, query string, args ...any) *lockedRow {
+	unlock := db.lock()
+	return &lockedRow{row: db.executor.QueryRow(ctx, query, args...), unlock: unlock}
+}
+
+// lockedRows unlocks a transaction when all rows are read or closed.
+type lockedRows struct {
+	pgx.Rows
+	unlock func()
+}
+
+func (r *lockedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.release()
+	return false
+}
+
+func (r *lockedRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+func (r *lockedRows) release() {
+	if r.unlock != nil {
+		r.unlock()
+		r.unlock = nil
 	}
-	return tx.Commit(ctx)
+}
+
+// lockedRow unlocks a transaction when a row is scanned.
+type lockedRow struct {
+	row    pgx.Row
+	unlock func()
+}
+
+func (r *lockedRow) Scan(dest ...any) error {
+	defer r.unlock()
+	return r.row.Scan(dest...)
+}
+
+// AfterCommit registers a function executed after a transaction the DB is bound to is committed.
+// The function is executed immediately if the DB isn't bound to a transaction,
+// so hohin.AfterCommit must be used to register it in a transaction stored in a context.
+func (db *DB) AfterCommit(f func()) {
+	db.hooks.AfterCommit(f)
+}
+
+// AfterRollback registers a function executed after a transaction the DB is bound to is rolled back.
+func (db *DB) AfterRollback(f func()) {
+	db.hooks.AfterRollback(f)
 }
 
 func (db *DB) Simple() hohin.SimpleDB {
@@ -87,12 +291,16 @@ type Repo[T any] struct {
 	load            func(Scanner) (T, error)
 	afterAdd        func(T) []*sqldb.SQL
 	afterUpdate     func(T) []*sqldb.SQL
+	strictUpdate    bool
+	key             string
+	readOnly        map[string]bool
+	version         string
 }
 
 // Conf contains configuration of a [Repo].
 type Conf[T any] struct {
 	Table   string            // name of a database table
-	Mapping map[string]string // mapping of entity fields to table columns
+	Mapping map[string]string // mapping of entity fields to table columns, built from struct tags by default
 	Query   string            // SQL query to select records from the database
 	// function that transforms an entity to a map where keys are
 	// column names of a database table and values are data for a row in that table
@@ -102,7 +310,17 @@ type Conf[T any] struct {
 	// function that builds and returns a sequence of SQL queries to execute after a call of [Repo.Add]
 	AfterAdd func(T) []*sqldb.SQL
 	// function that builds and returns a sequence of SQL queries to execute after a call of [Repo.Update]
+	// or [Repo.Upsert] that writes a record of an entity
 	AfterUpdate func(T) []*sqldb.SQL
+	// if true then [Repo.Update] returns hohin.NotFound when no records are updated
+	StrictUpdate bool
+	// field used to order entities with equal values of other fields in [Repo.GetPage],
+	// a field with the "pk" tag option or "Id" if the mapping contains it by default
+	Key string
+	// integer field used for optimistic locking, a field with the "version" tag option by default;
+	// [Repo.Update] increments it and returns hohin.ErrStaleEntity
+	// if a stored entity has another version
+	Version string
 }
 
 // NewRepo creates a [Repo].
@@ -113,23 +331,19 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 
 	r := &Repo[T]{table: conf.Table}
 
+	entityFields := fields.Of(reflect.TypeOf((*T)(nil)).Elem())
 	if conf.Mapping != nil {
 		r.mapping = conf.Mapping
 	} else {
-		var entity T
-		v := reflect.ValueOf(entity)
-		t := v.Type()
-		r.mapping = make(map[string]string)
-		for i := 0; i < v.NumField(); i++ {
-			field := t.Field(i).Name
-			r.mapping[field] = field
-		}
+		r.mapping = fields.Mapping(entityFields)
 	}
+	r.readOnly = fields.ReadOnly(entityFields)
 
 	r.fields, r.columns = maps.Split(r.mapping)
 
 	if conf.Query != "" {
 		r.query = conf.Query
+		r.queryCustomized = true
 	} else {
 		r.query = NewSQL("SELECT ").Join(", ", r.columns...).Add(" FROM ", r.table).String()
 	}
@@ -141,8 +355,11 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 			v := reflect.ValueOf(entity)
 			data := make(map[string]any)
 			for i, field := range r.fields {
+				if r.readOnly[field] {
+					continue
+				}
 				col := r.columns[i]
-				data[col] = v.FieldByName(field).Interface()
+				data[col] = fields.ByName(v, field).Interface()
 			}
 			return data, nil
 		}
@@ -154,18 +371,40 @@ func NewRepo[T any](conf Conf[T]) *Repo[T] {
 		r.load = func(row Scanner) (T, error) {
 			var entity T
 			a := reflect.ValueOf(&entity)
-			fields := make([]any, 0, len(r.fields))
+			dest := make([]any, 0, len(r.fields))
 			for _, field := range r.fields {
-				addr := a.Elem().FieldByName(field).Addr().Interface()
-				fields = append(fields, addr)
+				addr := fields.ByName(a.Elem(), field).Addr().Interface()
+				dest = append(dest, addr)
 			}
-			err := row.Scan(fields...)
+			err := row.Scan(dest...)
 			return entity, err
 		}
 	}
 
 	r.afterAdd = conf.AfterAdd
 	r.afterUpdate = conf.AfterUpdate
+	r.strictUpdate = conf.StrictUpdate
+
+	if conf.Key != "" {
+		r.key = conf.Key
+	} else if key := fields.Key(entityFields); key != "" {
+		r.key = key
+	} else if _, ok := r.mapping["Id"]; ok {
+		r.key = "Id"
+	}
+
+	if conf.Version != "" {
+		r.version = conf.Version
+	} else {
+		r.version = fields.Version(entityFields)
+	}
+	if r.version != "" {
+		t, ok := fields.TypeByName(reflect.TypeOf((*T)(nil)).Elem(), r.version)
+		if !ok || !fields.IsInteger(t) {
+			panic(fmt.Sprintf("version field `%s` must be an integer field of an entity", r.version))
+		}
+	}
+
 	return r
 }
 
@@ -178,28 +417,52 @@ func (r *Repo[T]) Get(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error
 	if r.load == nil {
 		return zero, errors.New("repository isn't configured to load entities")
 	}
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	sqlBuilder := NewSQL(r.query, " WHERE ")
 	if err := r.applyFilter(sqlBuilder, f); err != nil {
 		return zero, err
 	}
 	query, params := sqlBuilder.Build()
-	row := db.executor.QueryRow(ctx, query, params...)
+	row := db.queryRow(ctx, query, params...)
 	entity, err := r.load(row)
 	if err == pgx.ErrNoRows {
 		return zero, hohin.NotFound
 	}
 	if err != nil {
-		return zero, fmt.Errorf("%w while executing query `%s`", err, query)
+		return zero, queryError(query, err)
 	}
 	return entity, nil
 }
 
+// GetOne finds an entity and returns it like Get
+// but returns hohin.ErrMultipleFound if several entities match the filter.
+func (r *Repo[T]) GetOne(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
+	var zero T
+	entities, err := r.GetMany(ctx, d, hohin.Query{Filter: f, Limit: 2})
+	if err != nil {
+		return zero, err
+	}
+	switch len(entities) {
+	case 0:
+		return zero, hohin.NotFound
+	case 1:
+		return entities[0], nil
+	default:
+		return zero, hohin.ErrMultipleFound
+	}
+}
+
+// likeEscape is added to LIKE expressions whose patterns are escaped with hohin.EscapeLike.
+const likeEscape = ` ESCAPE '\'`
+
 func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 	col, ok := r.mapping[f.Field]
 	if len(f.Field) > 0 && !ok {
 		return fmt.Errorf("unknown field `%s` in a filter", f.Field)
 	}
+	if ref, ok := f.Value.(hohin.FieldRef); ok {
+		return r.compareFields(s, col, f.Operation, ref)
+	}
 	switch f.Operation {
 	case operations.Not:
 		s.Add("NOT (")
@@ -228,6 +491,8 @@ func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 		s.RemoveLast()
 	case operations.IsNull:
 		s.Add(col, " IS NULL")
+	case operations.IsNotNull:
+		s.Add(col, " IS NOT NULL")
 	case operations.Eq:
 		s.Add(col, " = ").Param(f.Value)
 	case operations.IEq:
@@ -244,59 +509,125 @@ func (r *Repo[T]) applyFilter(s *sqldb.SQL, f hohin.Filter) error {
 		s.Add(col, " <= ").Param(f.Value)
 	case operations.Gte:
 		s.Add(col, " >= ").Param(f.Value)
-	case operations.In:
-		switch val := f.Value.(type) {
-		case []any:
-			s.Add(col, " IN (").JoinParams(", ", val...).Add(")")
+	case operations.Between:
+		bounds := f.Value.([]any)
+		s.Add(col, " BETWEEN ").Param(bounds[0]).Add(" AND ").Param(bounds[1])
+	case operations.In, operations.NotIn:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		not := f.Operation == operations.NotIn
+		switch {
+		case len(items) == 0 && not:
+			s.Add("1 = 1")
+		case len(items) == 0:
+			s.Add("1 = 0")
+		case not:
+			s.Add(col, " NOT IN (").JoinParams(", ", items...).Add(")")
 		default:
-			return fmt.Errorf("operation %s is not supported for %T", f.Operation, val)
+			s.Add(col, " IN (").JoinParams(", ", items...).Add(")")
 		}
 	case operations.Contains:
-		s.Add(col, " LIKE '%' || ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " LIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%'", likeEscape)
 	case operations.IContains:
-		s.Add(col, " ILIKE '%' || ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " ILIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%'", likeEscape)
 	case operations.HasPrefix:
-		s.Add(col, " LIKE ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " LIKE ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%'", likeEscape)
 	case operations.IHasPrefix:
-		s.Add(col, " ILIKE ").Param(f.Value).Add(" || '%' ")
+		s.Add(col, " ILIKE ").Param(hohin.EscapeLike(f.Value.(string))).Add(" || '%'", likeEscape)
 	case operations.HasSuffix:
-		s.Add(col, " LIKE '%' || ").Param(f.Value)
+		s.Add(col, " LIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(likeEscape)
 	case operations.IHasSuffix:
-		s.Add(col, " ILIKE '%' || ").Param(f.Value)
+		s.Add(col, " ILIKE '%' || ").Param(hohin.EscapeLike(f.Value.(string))).Add(likeEscape)
 	case operations.IPWithin:
 		s.Add(col, "::inet << ").Param(f.Value).Add("::inet")
+	case operations.Like:
+		s.Add(col, " LIKE ").Param(f.Value).Add(likeEscape)
+	case operations.ILike:
+		s.Add(col, " ILIKE ").Param(f.Value).Add(likeEscape)
+	case operations.Matches:
+		if err := hohin.CheckPattern(f.Value.(string)); err != nil {
+			return err
+		}
+		s.Add(col, " ~ ").Param(f.Value)
+	case operations.IMatches:
+		if err := hohin.CheckPattern(f.Value.(string)); err != nil {
+			return err
+		}
+		s.Add(col, " ~* ").Param(f.Value)
+	case operations.HasElement:
+		s.Add(col, " @> ").Param([]any{f.Value})
+	case operations.HasAny:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		s.Add(col, " && ").Param(items)
+	case operations.HasAll:
+		items, ok := hohin.Items(f.Value)
+		if !ok {
+			return fmt.Errorf("operation %s is not supported for %T", f.Operation, f.Value)
+		}
+		s.Add(col, " @> ").Param(items)
+	case operations.ArrayLen:
+		s.Add("cardinality(", col, ") = ").Param(f.Value)
 	default:
 		return fmt.Errorf("operation %s is not supported", f.Operation)
 	}
 	return nil
 }
 
+// compareFields adds a comparison of a column with a column of another field of an entity.
+func (r *Repo[T]) compareFields(s *sqldb.SQL, col string, op operations.Operation, ref hohin.FieldRef) error {
+	other, ok := r.mapping[string(ref)]
+	if !ok {
+		return fmt.Errorf("unknown field `%s` in a filter", ref)
+	}
+	switch op {
+	case operations.Eq, operations.Ne, operations.Lt, operations.Gt, operations.Lte, operations.Gte:
+		s.Add(col, " ", string(op), " ", other)
+	case operations.IEq:
+		s.Add("UPPER(", col, ") = UPPER(", other, ")")
+	case operations.INe:
+		s.Add("UPPER(", col, ") != UPPER(", other, ")")
+	default:
+		return fmt.Errorf("operation %s is not supported for %T", op, ref)
+	}
+	return nil
+}
+
 func (r *Repo[T]) GetForUpdate(ctx context.Context, d hohin.DB, f hohin.Filter) (T, error) {
 	var zero T
 	if r.load == nil {
 		return zero, errors.New("repository isn't configured to load entities")
 	}
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	sqlBuilder := NewSQL(r.query, " WHERE ")
 	if err := r.applyFilter(sqlBuilder, f); err != nil {
 		return zero, err
 	}
 	sqlBuilder.Add(" FOR UPDATE")
 	query, params := sqlBuilder.Build()
-	row := db.executor.QueryRow(ctx, query, params...)
+	row := db.queryRow(ctx, query, params...)
 	entity, err := r.load(row)
 	if err == pgx.ErrNoRows {
 		return zero, hohin.NotFound
 	}
 	if err != nil {
-		return zero, fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		return zero, queryError(query, err)
 	}
 	return entity, nil
 }
 
+// GetLocked finds an entity and locks it with a given lock.
+func (r *Repo[T]) GetLocked(ctx context.Context, d hohin.DB, f hohin.Filter, l hohin.Lock) (T, error) {
+	return r.GetFirst(ctx, d, hohin.Query{Filter: f, Lock: l})
+}
+
 func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool, error) {
 	var result bool
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	sql := NewSQL("SELECT EXISTS (", r.query, " WHERE ")
 	err := r.applyFilter(sql, f)
 	if err != nil {
@@ -304,43 +635,50 @@ func (r *Repo[T]) Exists(ctx context.Context, d hohin.DB, f hohin.Filter) (bool,
 	}
 	sql.Add(")")
 	query, params := sql.Build()
-	row := db.executor.QueryRow(ctx, query, params...)
+	row := db.queryRow(ctx, query, params...)
 	err = row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
 func (r *Repo[T]) Delete(ctx context.Context, d hohin.DB, f hohin.Filter) error {
-	db := d.(*DB)
+	_, err := r.DeleteCount(ctx, d, f)
+	return err
+}
+
+func (r *Repo[T]) DeleteCount(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64, error) {
+	db := d.(*DB).current(ctx)
 	sql := NewSQL("DELETE FROM ", r.table, " WHERE ")
-	err := r.applyFilter(sql, f)
+	if err := r.applyFilter(sql, f); err != nil {
+		return 0, err
+	}
 	query, params := sql.Build()
-	_, err = db.executor.Exec(ctx, query, params...)
+	tag, err := db.exec(ctx, query, params...)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		return 0, queryError(query, err)
 	}
-	return err
+	return uint64(tag.RowsAffected()), nil
 }
 
 func (r *Repo[T]) Add(ctx context.Context, d hohin.DB, entity T) error {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	data, err := r.dump(entity)
 	if err != nil {
 		return err
 	}
 	columns, values := maps.Split(data)
 	query, params := r.buildInsertQuery(columns, values)
-	_, err = db.executor.Exec(ctx, query, params...)
+	_, err = db.exec(ctx, query, params...)
 	if err != nil {
-		return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		return queryError(query, err)
 	}
 	if r.afterAdd != nil {
 		for _, sql := range r.afterAdd(entity) {
 			query, params := sql.Build()
-			if _, err := db.executor.Exec(ctx, query, params...); err != nil {
-				return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+			if _, err := db.exec(ctx, query, params...); err != nil {
+				return queryError(query, err)
 			}
 		}
 	}
@@ -360,7 +698,7 @@ func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 	if len(entities) == 0 {
 		return nil
 	}
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	var rows [][]any
 	var columns []string
 	for _, e := range entities {
@@ -380,21 +718,144 @@ func (r *Repo[T]) AddMany(ctx context.Context, d hohin.DB, entities []T) error {
 	for i, c := range columns {
 		columns[i] = strings.ToLower(c)
 	}
+	unlock := db.lock()
+	defer unlock()
 	_, err := db.executor.CopyFrom(
 		ctx,
 		pgx.Identifier{r.table},
 		columns,
 		pgx.CopyFromRows(rows),
 	)
-	return err
+	return classify(err)
+}
+
+// Upsert saves a new entity or updates an existing one using INSERT ... ON CONFLICT.
+// Conflict fields must correspond to a unique index of the table.
+func (r *Repo[T]) Upsert(ctx context.Context, d hohin.DB, entity T, conflictFields ...string) error {
+	return r.UpsertMany(ctx, d, []T{entity}, conflictFields...)
+}
+
+// UpsertMany saves or updates several entities with a single multi-row INSERT ... ON CONFLICT query.
+// Entities must not repeat values of conflict fields.
+// If the repository has queries to execute after an update,
+// entities are upserted with separate queries instead.
+func (r *Repo[T]) UpsertMany(ctx context.Context, d hohin.DB, entities []T, conflictFields ...string) error {
+	conflictColumns, err := r.conflictColumns(conflictFields)
+	if err != nil {
+		return err
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+	db := d.(*DB).current(ctx)
+	var rows [][]any
+	var columns []string
+	for _, e := range entities {
+		data, err := r.dump(e)
+		if err != nil {
+			return err
+		}
+		if columns == nil {
+			columns, _ = maps.Split(data)
+		}
+		var row []any
+		for _, c := range columns {
+			row = append(row, data[c])
+		}
+		rows = append(rows, row)
+	}
+	if r.afterUpdate == nil {
+		query, params := r.buildUpsertQuery(columns, rows, conflictColumns)
+		if _, err := db.exec(ctx, query, params...); err != nil {
+			return queryError(query, err)
+		}
+		return nil
+	}
+	// entities are upserted one by one to skip queries after an update of entities that aren't written
+	for i, row := range rows {
+		query, params := r.buildUpsertQuery(columns, [][]any{row}, conflictColumns)
+		tag, err := db.exec(ctx, query, params...)
+		if err != nil {
+			return queryError(query, err)
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		for _, sql := range r.afterUpdate(entities[i]) {
+			query, params := sql.Build()
+			if _, err := db.exec(ctx, query, params...); err != nil {
+				return queryError(query, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Repo[T]) conflictColumns(fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("conflict fields are required for upsert")
+	}
+	columns := make([]string, 0, len(fields))
+	for _, f := range fields {
+		col, ok := r.mapping[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown field `%s` in conflict fields", f)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+func (r *Repo[T]) buildUpsertQuery(columns []string, rows [][]any, conflictColumns []string) (string, []any) {
+	sql := NewSQL("INSERT INTO ", r.table, " (").Join(", ", columns...).Add(") VALUES ")
+	for _, row := range rows {
+		sql.Add("(").JoinParams(", ", row...).Add("), ")
+	}
+	sql.RemoveLast().Add(")")
+	sql.Add(" ON CONFLICT (").Join(", ", conflictColumns...).Add(") DO ")
+	conflicts := make(map[string]bool)
+	for _, c := range conflictColumns {
+		conflicts[c] = true
+	}
+	updated := false
+	for _, c := range columns {
+		if !conflicts[c] {
+			if !updated {
+				sql.Add("UPDATE SET ")
+				updated = true
+			}
+			sql.Add(c, " = EXCLUDED.", c, ", ")
+		}
+	}
+	if updated {
+		sql.RemoveLast()
+	} else {
+		sql.Add("NOTHING")
+	}
+	return sql.Build()
 }
 
 func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) error {
-	db := d.(*DB)
-	data, err := r.dump(entity)
+	count, err := r.UpdateCount(ctx, d, f, entity)
 	if err != nil {
 		return err
 	}
+	if r.version != "" && count == 0 {
+		return hohin.ErrStaleEntity
+	}
+	if r.strictUpdate && count == 0 {
+		return hohin.NotFound
+	}
+	return nil
+}
+
+func (r *Repo[T]) UpdateCount(ctx context.Context, d hohin.DB, f hohin.Filter, entity T) (uint64, error) {
+	db := d.(*DB).current(ctx)
+	f = r.versioned(f, &entity)
+	data, err := r.dump(entity)
+	if err != nil {
+		return 0, err
+	}
 	sql := NewSQL("UPDATE ", r.table, " SET ")
 	for k, v := range data {
 		sql.Add(k, " = ").Param(v).Add(", ")
@@ -402,26 +863,88 @@ func (r *Repo[T]) Update(ctx context.Context, d hohin.DB, f hohin.Filter, entity
 	sql.RemoveLast().Add(" WHERE ")
 	err = r.applyFilter(sql, f)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	query, params := sql.Build()
-	if _, err := db.executor.Exec(ctx, query, params...); err != nil {
-		return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+	tag, err := db.exec(ctx, query, params...)
+	if err != nil {
+		return 0, queryError(query, err)
 	}
-	if r.afterUpdate != nil {
+	count := uint64(tag.RowsAffected())
+	if r.afterUpdate != nil && count > 0 {
 		for _, sql := range r.afterUpdate(entity) {
 			query, params := sql.Build()
-			if _, err := db.executor.Exec(ctx, query, params...); err != nil {
-				return fmt.Errorf("cannot execute query `%s`: %w", query, err)
+			if _, err := db.exec(ctx, query, params...); err != nil {
+				return 0, queryError(query, err)
 			}
 		}
 	}
+	return count, nil
+}
+
+// versioned returns a filter that also matches the current version of an entity
+// and increments the version of the entity if the repository has a version field.
+func (r *Repo[T]) versioned(f hohin.Filter, entity *T) hohin.Filter {
+	if r.version == "" {
+		return f
+	}
+	version := fields.ByName(reflect.ValueOf(entity).Elem(), r.version)
+	versionFilter := hohin.Eq(r.version, version.Interface())
+	fields.Increment(version)
+	if f.Operation == "" {
+		return versionFilter
+	}
+	return hohin.And(f, versionFilter)
+}
+
+func (r *Repo[T]) UpdateFields(ctx context.Context, d hohin.DB, f hohin.Filter, set hohin.Set) (uint64, error) {
+	db := d.(*DB).current(ctx)
+	sql := NewSQL("UPDATE ", r.table, " SET ")
+	if err := r.applySet(sql, set); err != nil {
+		return 0, err
+	}
+	if f.Operation != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyFilter(sql, f); err != nil {
+			return 0, err
+		}
+	}
+	query, params := sql.Build()
+	tag, err := db.exec(ctx, query, params...)
+	if err != nil {
+		return 0, queryError(query, err)
+	}
+	return uint64(tag.RowsAffected()), nil
+}
+
+func (r *Repo[T]) applySet(s *sqldb.SQL, set hohin.Set) error {
+	if len(set) == 0 {
+		return errors.New("nothing to update")
+	}
+	for _, a := range set {
+		col, ok := r.mapping[a.Field]
+		if !ok {
+			return fmt.Errorf("unknown field `%s` in a set", a.Field)
+		}
+		switch a.Operation {
+		case operations.Assign:
+			s.Add(col, " = ").Param(a.Value)
+		case operations.Incr:
+			s.Add(col, " = ", col, " + ").Param(a.Value)
+		case operations.SetNull:
+			s.Add(col, " = NULL")
+		default:
+			return fmt.Errorf("operation %s is not supported", a.Operation)
+		}
+		s.Add(", ")
+	}
+	s.RemoveLast()
 	return nil
 }
 
 func (r Repo[T]) Count(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64, error) {
 	var result uint64
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	sql := NewSQL("SELECT COUNT(1) FROM (", r.query, " WHERE ")
 	err := r.applyFilter(sql, f)
 	if err != nil {
@@ -429,19 +952,97 @@ func (r Repo[T]) Count(ctx context.Context, d hohin.DB, f hohin.Filter) (uint64,
 	}
 	sql.Add(") AS q")
 	query, params := sql.Build()
-	row := db.executor.QueryRow(ctx, query, params...)
+	row := db.queryRow(ctx, query, params...)
 	err = row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
 func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T, error) {
-	db := d.(*DB)
 	result := make([]T, 0)
-	sql := NewSQL(r.query)
-	if q.Filter.Operation != "" {
+	err := r.Each(ctx, d, q, func(entity T) error {
+		result = append(result, entity)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *Repo[T]) Each(ctx context.Context, d hohin.DB, q hohin.Query, f func(T) error) error {
+	if q.Cursor != "" {
+		return errors.New("cursors can be used only with GetPage")
+	}
+	return r.each(ctx, d, q, f)
+}
+
+func (r *Repo[T]) GetPage(ctx context.Context, d hohin.DB, q hohin.Query) (hohin.Page[T], error) {
+	result := make([]T, 0)
+	err := r.each(ctx, d, keyset.Query(q, r.key), func(entity T) error {
+		result = append(result, entity)
+		return nil
+	})
+	if err != nil {
+		return hohin.Page[T]{}, err
+	}
+	return keyset.Page(result, q, r.key)
+}
+
+func (r *Repo[T]) each(ctx context.Context, d hohin.DB, q hohin.Query, f func(T) error) error {
+	db := d.(*DB).current(ctx)
+	sql, err := r.buildSelectQuery(q)
+	if err != nil {
+		return err
+	}
+	query, params := sql.Build()
+	rows, err := db.query(ctx, query, params...)
+	if err != nil {
+		return queryError(query, err)
+	}
+	defer rows.Close()
+	load := r.load
+	if len(q.Fields) > 0 {
+		load = r.loadFields(q.Fields)
+	}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entity, err := load(rows)
+		if err != nil {
+			return err
+		}
+		if err := f(entity); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+func (r *Repo[T]) buildSelectQuery(q hohin.Query) (*sqldb.SQL, error) {
+	sql, err := r.selectFields(q.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if q.Cursor != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyCursor(sql, q.Cursor, q.Order); err != nil {
+			return nil, err
+		}
+		if q.Filter.Operation != "" {
+			sql.Add(" AND (")
+			if err := r.applyFilter(sql, q.Filter); err != nil {
+				return nil, err
+			}
+			sql.Add(")")
+		}
+	} else if q.Filter.Operation != "" {
 		sql.Add(" WHERE ")
 		if err := r.applyFilter(sql, q.Filter); err != nil {
 			return nil, err
@@ -450,7 +1051,11 @@ func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T,
 	if len(q.Order) > 0 {
 		sql.Add(" ORDER BY ")
 		for _, o := range q.Order {
-			sql.Add(o.Field)
+			col, ok := r.mapping[o.Field]
+			if !ok {
+				return nil, fmt.Errorf("unknown field `%s` in an order", o.Field)
+			}
+			sql.Add(col)
 			if o.Desc {
 				sql.Add(" DESC")
 			}
@@ -464,21 +1069,86 @@ func (r *Repo[T]) GetMany(ctx context.Context, d hohin.DB, q hohin.Query) ([]T,
 	if q.Offset > 0 {
 		sql.Add(" OFFSET ").Param(q.Offset)
 	}
-	query, params := sql.Build()
-	rows, err := db.executor.Query(ctx, query, params...)
-	if err != nil {
-		return nil, fmt.Errorf("cannot execute query `%s`: %w", query, err)
+	applyLock(sql, q.Lock)
+	return sql, nil
+}
+
+// applyLock adds a locking clause to a query.
+func applyLock(s *sqldb.SQL, l hohin.Lock) {
+	switch l.Strength {
+	case hohin.ForUpdate:
+		s.Add(" FOR UPDATE")
+	case hohin.ForShare:
+		s.Add(" FOR SHARE")
+	default:
+		return
 	}
-	defer rows.Close()
-	for rows.Next() {
-		entity, err := r.load(rows)
-		if err != nil {
-			return nil, err
+	switch l.Wait {
+	case hohin.NoWait:
+		s.Add(" NOWAIT")
+	case hohin.SkipLocked:
+		s.Add(" SKIP LOCKED")
+	}
+}
+
+// selectFields starts a query that selects columns of given fields
+// or all columns if no fields are given.
+func (r *Repo[T]) selectFields(fields []string) (*sqldb.SQL, error) {
+	if len(fields) == 0 {
+		return NewSQL(r.query), nil
+	}
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		col, ok := r.mapping[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field `%s` in a projection", field)
 		}
-		result = append(result, entity)
+		columns = append(columns, col)
 	}
+	sql := NewSQL("SELECT ").Join(", ", columns...)
+	if r.queryCustomized {
+		sql.Add(" FROM (", r.query, ") AS q")
+	} else {
+		sql.Add(" FROM ", r.table)
+	}
+	return sql, nil
+}
 
-	return result, nil
+// loadFields returns a function that loads only given fields of an entity.
+func (r *Repo[T]) loadFields(names []string) func(Scanner) (T, error) {
+	return func(row Scanner) (T, error) {
+		var entity T
+		v := reflect.ValueOf(&entity).Elem()
+		dest := make([]any, 0, len(names))
+		for _, field := range names {
+			f := fields.ByName(v, field)
+			if !f.IsValid() {
+				return entity, fmt.Errorf("unknown field `%s` in a projection", field)
+			}
+			dest = append(dest, f.Addr().Interface())
+		}
+		err := row.Scan(dest...)
+		return entity, err
+	}
+}
+
+func (r *Repo[T]) applyCursor(s *sqldb.SQL, c hohin.Cursor, order []hohin.Order) error {
+	values, err := keyset.Values[T](c, order)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(order))
+	desc := make([]bool, 0, len(order))
+	for _, o := range order {
+		col, ok := r.mapping[o.Field]
+		if !ok {
+			return fmt.Errorf("unknown field `%s` in an order", o.Field)
+		}
+		columns = append(columns, col)
+		desc = append(desc, o.Desc)
+	}
+	s.Keyset(columns, desc, values)
+	return nil
 }
 
 func (r *Repo[T]) GetFirst(ctx context.Context, d hohin.DB, q hohin.Query) (T, error) {
@@ -496,22 +1166,135 @@ func (r *Repo[T]) GetFirst(ctx context.Context, d hohin.DB, q hohin.Query) (T, e
 
 func (r *Repo[T]) CountAll(ctx context.Context, d hohin.DB) (uint64, error) {
 	var result uint64
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	query := NewSQL("SELECT COUNT(1) FROM (", r.query, ") AS q").String()
-	row := db.executor.QueryRow(ctx, query)
+	row := db.queryRow(ctx, query)
 	err := row.Scan(&result)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return result, err
 }
 
+// Sum returns a sum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Sum(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "SUM", field, f)
+}
+
+// Avg returns an average of values of a field of entities matching a given filter.
+func (r *Repo[T]) Avg(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "AVG", field, f)
+}
+
+// Min returns a minimum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Min(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "MIN", field, f)
+}
+
+// Max returns a maximum of values of a field of entities matching a given filter.
+func (r *Repo[T]) Max(ctx context.Context, d hohin.DB, field string, f hohin.Filter) (decimal.Decimal, error) {
+	return r.aggregateField(ctx, d, "MAX", field, f)
+}
+
+func (r *Repo[T]) aggregateField(ctx context.Context, d hohin.DB, function string, field string, f hohin.Filter) (decimal.Decimal, error) {
+	db := d.(*DB).current(ctx)
+	col, ok := r.mapping[field]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("unknown field `%s` in an aggregation", field)
+	}
+	sql := NewSQL("SELECT ", function, "(", col, ") FROM (", r.query)
+	if f.Operation != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyFilter(sql, f); err != nil {
+			return decimal.Zero, err
+		}
+	}
+	sql.Add(") AS q")
+	query, params := sql.Build()
+	var result decimal.NullDecimal
+	row := db.queryRow(ctx, query, params...)
+	if err := row.Scan(&result); err != nil {
+		return decimal.Zero, queryError(query, err)
+	}
+	return result.Decimal, nil
+}
+
+func (r *Repo[T]) Aggregate(ctx context.Context, d hohin.DB, a hohin.Aggregation) ([]hohin.Group, error) {
+	db := d.(*DB).current(ctx)
+	col, ok := r.mapping[a.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field `%s` in an aggregation", a.Field)
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	groupColumns := make([]string, 0, len(a.GroupBy))
+	keyTypes := make([]reflect.Type, 0, len(a.GroupBy))
+	for _, field := range a.GroupBy {
+		groupCol, ok := r.mapping[field]
+		keyType, found := fields.TypeByName(t, field)
+		if !ok || !found {
+			return nil, fmt.Errorf("unknown field `%s` in a grouping", field)
+		}
+		groupColumns = append(groupColumns, groupCol)
+		keyTypes = append(keyTypes, keyType)
+	}
+
+	sql := NewSQL("SELECT ")
+	for _, groupCol := range groupColumns {
+		sql.Add(groupCol, ", ")
+	}
+	sql.Add("COUNT(1), SUM(", col, "), AVG(", col, "), MIN(", col, "), MAX(", col, ") FROM (", r.query)
+	if a.Filter.Operation != "" {
+		sql.Add(" WHERE ")
+		if err := r.applyFilter(sql, a.Filter); err != nil {
+			return nil, err
+		}
+	}
+	sql.Add(") AS q")
+	if len(groupColumns) > 0 {
+		sql.Add(" GROUP BY ").Join(", ", groupColumns...)
+		sql.Add(" ORDER BY ").Join(", ", groupColumns...)
+	}
+
+	query, params := sql.Build()
+	rows, err := db.query(ctx, query, params...)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+	result := make([]hohin.Group, 0)
+	for rows.Next() {
+		keys := make([]any, 0, len(keyTypes))
+		for _, keyType := range keyTypes {
+			keys = append(keys, reflect.New(keyType).Interface())
+		}
+		var count uint64
+		var sum, avg, min, max decimal.NullDecimal
+		if err := rows.Scan(append(keys, &count, &sum, &avg, &min, &max)...); err != nil {
+			return nil, queryError(query, err)
+		}
+		group := hohin.Group{Key: make([]any, 0, len(keys))}
+		for _, key := range keys {
+			group.Key = append(group.Key, reflect.ValueOf(key).Elem().Interface())
+		}
+		group.Count = count
+		group.Sum = sum.Decimal
+		group.Avg = avg.Decimal
+		group.Min = min.Decimal
+		group.Max = max.Decimal
+		result = append(result, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(query, err)
+	}
+	return result, nil
+}
+
 func (r *Repo[T]) Clear(ctx context.Context, d hohin.DB) error {
-	db := d.(*DB)
+	db := d.(*DB).current(ctx)
 	query := NewSQL("DELETE FROM ", r.table).String()
-	_, err := db.executor.Exec(ctx, query)
+	_, err := db.exec(ctx, query)
 	if err != nil {
-		err = fmt.Errorf("cannot execute query `%s`: %w", query, err)
+		err = queryError(query, err)
 	}
 	return err
 }
@@ -9,7 +9,7 @@ import (
 type pgDialect struct{}
 
 func (d pgDialect) ProcessParam(p any, number int) (string, any) {
-	if val, ok := p.(netip.Addr); ok {
+	if val, ok := sqldb.Deref(p).(netip.Addr); ok {
 		return fmt.Sprintf("$%d", number), val.String()
 	}
 	return fmt.Sprintf("$%d", number), p
@@ -0,0 +1,113 @@
+package hohin
+
+import (
+	"context"
+	"fmt"
+	"github.com/meowmeowcode/hohin/fields"
+	"reflect"
+	"strings"
+)
+
+// Project finds entities matching a query and converts them to values of type P.
+// Every exported field of P is filled from a field of T with the same name
+// and only these fields are loaded from the database.
+func Project[T, P any](ctx context.Context, db DB, r Repo[T], q Query) ([]P, error) {
+	pt := reflect.TypeOf((*P)(nil)).Elem()
+	tt := reflect.TypeOf((*T)(nil)).Elem()
+	entityFields := fields.Of(tt)
+	names := make([]string, 0, pt.NumField())
+	selected := make([]string, 0, pt.NumField())
+	for i := 0; i < pt.NumField(); i++ {
+		pf := pt.Field(i)
+		if !pf.IsExported() {
+			continue
+		}
+		tf, ok := tt.FieldByName(pf.Name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field `%s` in a projection", pf.Name)
+		}
+		if !convertible(tf.Type, pf.Type) {
+			return nil, fmt.Errorf("field `%s` of type %s cannot be converted to %s", pf.Name, tf.Type, pf.Type)
+		}
+		names = append(names, pf.Name)
+		selected = append(selected, storedFields(entityFields, pf.Name)...)
+	}
+
+	q.Fields = selected
+	entities, err := r.GetMany(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]P, 0, len(entities))
+	for _, entity := range entities {
+		var p P
+		pv := reflect.ValueOf(&p).Elem()
+		tv := reflect.ValueOf(entity)
+		for _, field := range names {
+			value := tv.FieldByName(field)
+			pf := pv.FieldByName(field)
+			pf.Set(value.Convert(pf.Type()))
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// Pluck finds entities matching a query and returns values of one of their fields.
+// Only this field is loaded from the database.
+func Pluck[T, V any](ctx context.Context, db DB, r Repo[T], field string, q Query) ([]V, error) {
+	vt := reflect.TypeOf((*V)(nil)).Elem()
+	tt := reflect.TypeOf((*T)(nil)).Elem()
+	ft, ok := fields.TypeByName(tt, field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field `%s` in a projection", field)
+	}
+	if !convertible(ft, vt) {
+		return nil, fmt.Errorf("field `%s` of type %s cannot be converted to %s", field, ft, vt)
+	}
+
+	q.Fields = storedFields(fields.Of(tt), field)
+	entities, err := r.GetMany(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]V, 0, len(entities))
+	for _, entity := range entities {
+		value := fields.ByName(reflect.ValueOf(entity), field).Convert(vt)
+		result = append(result, value.Interface().(V))
+	}
+	return result, nil
+}
+
+// storedFields returns names of stored fields that must be loaded to fill a given field.
+// There are several of them if the field is a nested struct.
+func storedFields(entityFields []fields.Field, name string) []string {
+	result := make([]string, 0)
+	for _, f := range entityFields {
+		if f.Name == name || strings.HasPrefix(f.Name, name+".") {
+			result = append(result, f.Name)
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, name)
+	}
+	return result
+}
+
+// convertible checks if values of one type can be converted to another one
+// without changing their meaning, e.g. a string to a named string type.
+func convertible(from, to reflect.Type) bool {
+	return from.AssignableTo(to) || (from.ConvertibleTo(to) && from.Kind() == to.Kind())
+}
+
+// SimpleProject is similar to [Project] but works with a [SimpleRepo].
+func SimpleProject[T, P any](db SimpleDB, r SimpleRepo[T], q Query) ([]P, error) {
+	return Project[T, P](context.Background(), db.db, r.repo, q)
+}
+
+// SimplePluck is similar to [Pluck] but works with a [SimpleRepo].
+func SimplePluck[T, V any](db SimpleDB, r SimpleRepo[T], field string, q Query) ([]V, error) {
+	return Pluck[T, V](context.Background(), db.db, r.repo, field, q)
+}
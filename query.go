@@ -2,10 +2,14 @@ package hohin
 
 // Query contains a [Filter] and additional options.
 type Query struct {
-	Filter Filter  // filter to search entities
-	Limit  int     // maximum number of entities to retrieve
-	Offset int     // result offset
-	Order  []Order // order of entities
+	Filter   Filter   // filter to search entities
+	Limit    int      // maximum number of entities to retrieve
+	Offset   int      // result offset
+	Order    []Order  // order of entities
+	Cursor   Cursor   // position in the order after or before which entities are retrieved
+	Backward bool     // defines if entities must be retrieved before the cursor instead of after it
+	Fields   []string // fields to load, all fields are loaded if it's empty
+	Lock     Lock     // lock of retrieved entities
 }
 
 // OrderBy sets the Order field.
@@ -13,3 +17,56 @@ func (q Query) OrderBy(o ...Order) Query {
 	q.Order = o
 	return q
 }
+
+// Select sets the Fields field.
+// Other fields of retrieved entities keep zero values.
+func (q Query) Select(fields ...string) Query {
+	q.Fields = fields
+	return q
+}
+
+// After sets a cursor to retrieve entities that follow it.
+func (q Query) After(c Cursor) Query {
+	q.Cursor = c
+	q.Backward = false
+	return q
+}
+
+// Before sets a cursor to retrieve entities that precede it.
+func (q Query) Before(c Cursor) Query {
+	q.Cursor = c
+	q.Backward = true
+	return q
+}
+
+// ForUpdate sets a lock that prevents other transactions from locking retrieved entities.
+func (q Query) ForUpdate() Query {
+	q.Lock.Strength = ForUpdate
+	return q
+}
+
+// ForShare sets a lock that prevents other transactions from changing retrieved entities.
+func (q Query) ForShare() Query {
+	q.Lock.Strength = ForShare
+	return q
+}
+
+// NoWait makes the query fail if entities are locked by another transaction.
+// The query locks entities for update unless another lock is set.
+func (q Query) NoWait() Query {
+	if q.Lock.Strength == NoLock {
+		q.Lock.Strength = ForUpdate
+	}
+	q.Lock.Wait = NoWait
+	return q
+}
+
+// SkipLocked makes the query skip entities locked by another transaction.
+// The query locks entities for update unless another lock is set.
+func (q Query) SkipLocked() Query {
+	if q.Lock.Strength == NoLock {
+		q.Lock.Strength = ForUpdate
+	}
+	q.Lock.Wait = SkipLocked
+	return q
+}
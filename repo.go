@@ -4,36 +4,85 @@ package hohin
 import (
 	"context"
 	"errors"
+	"github.com/shopspring/decimal"
 )
 
 // NotFound is returned when an entity cannot be found in the repository.
 var NotFound error = errors.New("object not found")
 
+// ErrMultipleFound is returned by [Repo.GetOne] when several entities match a filter.
+var ErrMultipleFound error = errors.New("multiple objects found")
+
+// ErrStaleEntity is returned by [Repo.Update] of a repository with a version field
+// when the entity was changed or removed after it had been loaded.
+var ErrStaleEntity error = errors.New("object is stale")
+
 // Repo is a repository of entities.
 // It saves entities to the database and loads or deletes them from it.
 type Repo[T any] interface {
 	// Get finds an entity and returns it.
 	Get(context.Context, DB, Filter) (T, error)
+	// GetOne finds an entity and returns it like Get
+	// but returns ErrMultipleFound if several entities match the filter.
+	GetOne(context.Context, DB, Filter) (T, error)
 	// GetForUpdate finds an entity and locks it for update.
 	GetForUpdate(context.Context, DB, Filter) (T, error)
+	// GetLocked finds an entity and locks it with a given lock.
+	GetLocked(context.Context, DB, Filter, Lock) (T, error)
 	// GetMany finds and returns several entities.
 	GetMany(context.Context, DB, Query) ([]T, error)
 	// GetFirst finds and returns the first entity matching given criteria.
 	GetFirst(context.Context, DB, Query) (T, error)
+	// Each calls a function for every entity matching a query
+	// without loading all of them into memory at once.
+	// Iteration stops when the function returns an error, which is returned by Each,
+	// or when the context is cancelled.
+	Each(context.Context, DB, Query, func(T) error) error
+	// GetPage finds entities using keyset pagination
+	// and returns them with cursors to neighbouring pages.
+	// Entities are ordered by fields of the query and then by a key field of the repository.
+	// The Offset of the query is ignored.
+	GetPage(context.Context, DB, Query) (Page[T], error)
 	// Add saves a new entity to the repository.
 	Add(context.Context, DB, T) error
 	// AddMany saves several entities to the repository.
 	AddMany(context.Context, DB, []T) error
+	// Upsert saves a new entity to the repository or updates an existing one
+	// if it has the same values of given conflict fields.
+	Upsert(context.Context, DB, T, ...string) error
+	// UpsertMany saves or updates several entities.
+	UpsertMany(context.Context, DB, []T, ...string) error
 	// Update saves an updated entity.
+	// If the repository has a version field then only a record with the version of the entity is updated,
+	// the stored version is incremented and ErrStaleEntity is returned if no records are updated.
 	Update(context.Context, DB, Filter, T) error
+	// UpdateCount saves an updated entity and returns a number of updated records.
+	UpdateCount(context.Context, DB, Filter, T) (uint64, error)
+	// UpdateFields changes fields of entities matching a given filter
+	// and returns a number of updated entities.
+	UpdateFields(context.Context, DB, Filter, Set) (uint64, error)
 	// Delete removes entities matching a given filter.
 	Delete(context.Context, DB, Filter) error
+	// DeleteCount removes entities matching a given filter
+	// and returns a number of removed entities.
+	DeleteCount(context.Context, DB, Filter) (uint64, error)
 	// Exists checks if there is an entity matching a given filter.
 	Exists(context.Context, DB, Filter) (bool, error)
 	// Count returns a number of entities matching a given filter.
 	Count(context.Context, DB, Filter) (uint64, error)
 	// CountAll returns a number of all entities in the repository.
 	CountAll(context.Context, DB) (uint64, error)
+	// Sum returns a sum of values of a field of entities matching a given filter.
+	Sum(context.Context, DB, string, Filter) (decimal.Decimal, error)
+	// Avg returns an average of values of a field of entities matching a given filter.
+	Avg(context.Context, DB, string, Filter) (decimal.Decimal, error)
+	// Min returns a minimum of values of a field of entities matching a given filter.
+	Min(context.Context, DB, string, Filter) (decimal.Decimal, error)
+	// Max returns a maximum of values of a field of entities matching a given filter.
+	Max(context.Context, DB, string, Filter) (decimal.Decimal, error)
+	// Aggregate computes aggregates over groups of entities.
+	// Groups are ordered by values of fields used for grouping.
+	Aggregate(context.Context, DB, Aggregation) ([]Group, error)
 	// Clear removes all entities from the repository.
 	Clear(context.Context, DB) error
 	// Simple returns the repository wrapped into an object with a simplified interface.
@@ -44,9 +93,28 @@ type Repo[T any] interface {
 type DB interface {
 	// Transaction executes a given function within a transaction.
 	// If the function returns an error then the transaction rolls back.
+	// If the function panics then the transaction rolls back and the panic is propagated.
+	// A call on a DB bound to a transaction starts a nested transaction
+	// that rolls back only its own changes.
 	Transaction(context.Context, func(context.Context, DB) error) error
 	// Tx is similar to Transaction but requires to choose an isolation level.
 	Tx(context.Context, IsolationLevel, func(context.Context, DB) error) error
+	// TxWithOptions is similar to Transaction but allows to set options of the transaction.
+	// Options of a transaction are kept by nested transactions.
+	TxWithOptions(context.Context, TxOptions, func(context.Context, DB) error) error
+	// TxRetry is similar to Tx but executes the function again in a new transaction
+	// when the transaction fails because of a serialization failure or a deadlock.
+	// A call on a DB bound to a transaction is not retried
+	// because only the outermost transaction can be executed again.
+	TxRetry(context.Context, IsolationLevel, RetryPolicy, func(context.Context, DB) error) error
+	// AfterCommit registers a function executed after a transaction the DB is bound to is committed.
+	// Functions registered within a nested transaction are executed after the outermost transaction is committed.
+	// The function is executed immediately if the DB isn't bound to a transaction.
+	// A root DB that stores transactions in contexts isn't bound to them,
+	// so the package-level [AfterCommit] must be used to register functions in such transactions.
+	AfterCommit(func())
+	// AfterRollback registers a function executed after a transaction the DB is bound to is rolled back.
+	AfterRollback(func())
 	// Simple returns the DB wrapped into an object with a simplified interface.
 	Simple() SimpleDB
 }
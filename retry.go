@@ -0,0 +1,46 @@
+package hohin
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy defines how a transaction is retried by [DB.TxRetry]
+// when it fails because of a conflict with another transaction.
+type RetryPolicy struct {
+	MaxAttempts int           // maximum number of attempts, a transaction is executed once if it's less than 2
+	Backoff     time.Duration // delay before the second attempt, it's doubled before every next attempt
+	MaxBackoff  time.Duration // maximum delay between attempts, the delay is not limited if it's zero
+}
+
+// DefaultRetryPolicy is a [RetryPolicy] suitable for most applications.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+// Retry calls a function until it succeeds, returns an error that isn't retryable,
+// the maximum number of attempts is reached or the context is done.
+// The last error returned by the function is returned.
+// It's intended to be used by implementations of [DB.TxRetry].
+func Retry(ctx context.Context, policy RetryPolicy, retryable func(error) bool, f func() error) error {
+	delay := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+	}
+}
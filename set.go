@@ -0,0 +1,30 @@
+package hohin
+
+import "github.com/meowmeowcode/hohin/operations"
+
+// Assignment describes how a field of an entity must be changed
+// by [Repo.UpdateFields].
+type Assignment struct {
+	Field     string               // name of an entity field
+	Operation operations.Operation // assignment operation
+	Value     any                  // value used by an operation
+}
+
+// Set is a list of assignments applied to entities by [Repo.UpdateFields].
+type Set []Assignment
+
+// Assign creates an assignment that sets a field to a given value.
+func Assign(field string, value any) Assignment {
+	return Assignment{Field: field, Operation: operations.Assign, Value: value}
+}
+
+// Incr creates an assignment that increases a numeric field by a given value.
+// A negative value decreases the field.
+func Incr(field string, value any) Assignment {
+	return Assignment{Field: field, Operation: operations.Incr, Value: value}
+}
+
+// SetNull creates an assignment that sets a field to null.
+func SetNull(field string) Assignment {
+	return Assignment{Field: field, Operation: operations.SetNull}
+}
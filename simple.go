@@ -1,6 +1,9 @@
 package hohin
 
-import "context"
+import (
+	"context"
+	"github.com/shopspring/decimal"
+)
 
 // SimpleRepo is a wrapper around a [Repo] for a case
 // when there is no need of passing a Context to Repo's methods.
@@ -18,11 +21,22 @@ func (r *SimpleRepo[T]) Get(db SimpleDB, f Filter) (T, error) {
 	return r.repo.Get(context.Background(), db.db, f)
 }
 
+// GetOne finds an entity and returns it like Get
+// but returns ErrMultipleFound if several entities match the filter.
+func (r *SimpleRepo[T]) GetOne(db SimpleDB, f Filter) (T, error) {
+	return r.repo.GetOne(context.Background(), db.db, f)
+}
+
 // GetForUpdate finds an entity and locks it for update.
 func (r *SimpleRepo[T]) GetForUpdate(db SimpleDB, f Filter) (T, error) {
 	return r.repo.GetForUpdate(context.Background(), db.db, f)
 }
 
+// GetLocked finds an entity and locks it with a given lock.
+func (r *SimpleRepo[T]) GetLocked(db SimpleDB, f Filter, l Lock) (T, error) {
+	return r.repo.GetLocked(context.Background(), db.db, f, l)
+}
+
 // GetMany finds and returns several entities.
 func (r *SimpleRepo[T]) GetMany(db SimpleDB, q Query) ([]T, error) {
 	return r.repo.GetMany(context.Background(), db.db, q)
@@ -33,6 +47,19 @@ func (r *SimpleRepo[T]) GetFirst(db SimpleDB, q Query) (T, error) {
 	return r.repo.GetFirst(context.Background(), db.db, q)
 }
 
+// Each calls a function for every entity matching a query
+// without loading all of them into memory at once.
+// Iteration stops when the function returns an error, which is returned by Each.
+func (r *SimpleRepo[T]) Each(db SimpleDB, q Query, f func(T) error) error {
+	return r.repo.Each(context.Background(), db.db, q, f)
+}
+
+// GetPage finds entities using keyset pagination
+// and returns them with cursors to neighbouring pages.
+func (r *SimpleRepo[T]) GetPage(db SimpleDB, q Query) (Page[T], error) {
+	return r.repo.GetPage(context.Background(), db.db, q)
+}
+
 // Add saves a new entity to the repository.
 func (r *SimpleRepo[T]) Add(db SimpleDB, entity T) error {
 	return r.repo.Add(context.Background(), db.db, entity)
@@ -43,16 +70,44 @@ func (r *SimpleRepo[T]) AddMany(db SimpleDB, entities []T) error {
 	return r.repo.AddMany(context.Background(), db.db, entities)
 }
 
+// Upsert saves a new entity to the repository or updates an existing one
+// if it has the same values of given conflict fields.
+func (r *SimpleRepo[T]) Upsert(db SimpleDB, entity T, conflictFields ...string) error {
+	return r.repo.Upsert(context.Background(), db.db, entity, conflictFields...)
+}
+
+// UpsertMany saves or updates several entities.
+func (r *SimpleRepo[T]) UpsertMany(db SimpleDB, entities []T, conflictFields ...string) error {
+	return r.repo.UpsertMany(context.Background(), db.db, entities, conflictFields...)
+}
+
 // Update saves an updated entity.
 func (r *SimpleRepo[T]) Update(db SimpleDB, f Filter, entity T) error {
 	return r.repo.Update(context.Background(), db.db, f, entity)
 }
 
+// UpdateCount saves an updated entity and returns a number of updated records.
+func (r *SimpleRepo[T]) UpdateCount(db SimpleDB, f Filter, entity T) (uint64, error) {
+	return r.repo.UpdateCount(context.Background(), db.db, f, entity)
+}
+
+// UpdateFields changes fields of entities matching a given filter
+// and returns a number of updated entities.
+func (r *SimpleRepo[T]) UpdateFields(db SimpleDB, f Filter, s Set) (uint64, error) {
+	return r.repo.UpdateFields(context.Background(), db.db, f, s)
+}
+
 // Delete removes entities matching a given filter.
 func (r *SimpleRepo[T]) Delete(db SimpleDB, f Filter) error {
 	return r.repo.Delete(context.Background(), db.db, f)
 }
 
+// DeleteCount removes entities matching a given filter
+// and returns a number of removed entities.
+func (r *SimpleRepo[T]) DeleteCount(db SimpleDB, f Filter) (uint64, error) {
+	return r.repo.DeleteCount(context.Background(), db.db, f)
+}
+
 // Exists checks if there is an entity matching a given filter.
 func (r *SimpleRepo[T]) Exists(db SimpleDB, f Filter) (bool, error) {
 	return r.repo.Exists(context.Background(), db.db, f)
@@ -68,6 +123,32 @@ func (r *SimpleRepo[T]) CountAll(db SimpleDB) (uint64, error) {
 	return r.repo.CountAll(context.Background(), db.db)
 }
 
+// Sum returns a sum of values of a field of entities matching a given filter.
+func (r *SimpleRepo[T]) Sum(db SimpleDB, field string, f Filter) (decimal.Decimal, error) {
+	return r.repo.Sum(context.Background(), db.db, field, f)
+}
+
+// Avg returns an average of values of a field of entities matching a given filter.
+func (r *SimpleRepo[T]) Avg(db SimpleDB, field string, f Filter) (decimal.Decimal, error) {
+	return r.repo.Avg(context.Background(), db.db, field, f)
+}
+
+// Min returns a minimum of values of a field of entities matching a given filter.
+func (r *SimpleRepo[T]) Min(db SimpleDB, field string, f Filter) (decimal.Decimal, error) {
+	return r.repo.Min(context.Background(), db.db, field, f)
+}
+
+// Max returns a maximum of values of a field of entities matching a given filter.
+func (r *SimpleRepo[T]) Max(db SimpleDB, field string, f Filter) (decimal.Decimal, error) {
+	return r.repo.Max(context.Background(), db.db, field, f)
+}
+
+// Aggregate computes aggregates over groups of entities.
+// Groups are ordered by values of fields used for grouping.
+func (r *SimpleRepo[T]) Aggregate(db SimpleDB, a Aggregation) ([]Group, error) {
+	return r.repo.Aggregate(context.Background(), db.db, a)
+}
+
 // Clear removes all entities from the repository.
 func (r *SimpleRepo[T]) Clear(db SimpleDB) error {
 	return r.repo.Clear(context.Background(), db.db)
@@ -81,6 +162,7 @@ type SimpleDB struct {
 
 // Transaction executes a given function within a transaction.
 // If the function returns an error then the transaction rolls back.
+// If the function panics then the transaction rolls back and the panic is propagated.
 func (d *SimpleDB) Transaction(f func(db SimpleDB) error) error {
 	return d.db.Transaction(context.Background(), func(_ context.Context, db DB) error {
 		return f(db.Simple())
@@ -94,6 +176,32 @@ func (d *SimpleDB) Tx(l IsolationLevel, f func(db SimpleDB) error) error {
 	})
 }
 
+// TxWithOptions is similar to Transaction but allows to set options of the transaction.
+func (d *SimpleDB) TxWithOptions(o TxOptions, f func(db SimpleDB) error) error {
+	return d.db.TxWithOptions(context.Background(), o, func(_ context.Context, db DB) error {
+		return f(db.Simple())
+	})
+}
+
+// TxRetry is similar to Tx but executes the function again in a new transaction
+// when the transaction fails because of a serialization failure or a deadlock.
+func (d *SimpleDB) TxRetry(l IsolationLevel, p RetryPolicy, f func(db SimpleDB) error) error {
+	return d.db.TxRetry(context.Background(), l, p, func(_ context.Context, db DB) error {
+		return f(db.Simple())
+	})
+}
+
+// AfterCommit registers a function executed after a transaction the DB is bound to is committed.
+// The function is executed immediately if the DB isn't bound to a transaction.
+func (d *SimpleDB) AfterCommit(f func()) {
+	d.db.AfterCommit(f)
+}
+
+// AfterRollback registers a function executed after a transaction the DB is bound to is rolled back.
+func (d *SimpleDB) AfterRollback(f func()) {
+	d.db.AfterRollback(f)
+}
+
 // Creates [SimpleDB].
 func NewSimpleDB(db DB) SimpleDB {
 	return SimpleDB{db: db}
@@ -2,6 +2,7 @@
 package sqldb
 
 import (
+	"reflect"
 	"strings"
 )
 
@@ -84,3 +85,61 @@ func (s *SQL) Params() []any {
 func (s *SQL) Build() (string, []any) {
 	return s.String(), s.Params()
 }
+
+// Keyset appends a condition that selects rows following a given position
+// in an ordering by given columns. Adjacent columns sorted in the same direction
+// are compared as row values, for example `(a, b) > (?, ?)`.
+func (s *SQL) Keyset(columns []string, desc []bool, values []any) *SQL {
+	var runs [][2]int
+	start := 0
+	for i := 1; i <= len(columns); i++ {
+		if i == len(columns) || desc[i] != desc[start] {
+			runs = append(runs, [2]int{start, i})
+			start = i
+		}
+	}
+
+	if len(runs) > 1 {
+		s.Add("(")
+	}
+	for i, run := range runs {
+		if len(runs) > 1 {
+			s.Add("(")
+		}
+		for j := 0; j < run[0]; j++ {
+			s.Add(columns[j], " = ").Param(values[j]).Add(" AND ")
+		}
+		op := " > "
+		if desc[run[0]] {
+			op = " < "
+		}
+		s.Add("(").Join(", ", columns[run[0]:run[1]]...).Add(")", op, "(")
+		s.JoinParams(", ", values[run[0]:run[1]]...).Add(")")
+		if len(runs) > 1 {
+			s.Add(")")
+			if i < len(runs)-1 {
+				s.Add(" OR ")
+			}
+		}
+	}
+	if len(runs) > 1 {
+		s.Add(")")
+	}
+	return s
+}
+
+// Deref returns a value a pointer points to, or nil if the pointer is nil.
+// Other values are returned as is.
+func Deref(p any) any {
+	v := reflect.ValueOf(p)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
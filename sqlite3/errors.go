@@ -0,0 +1,55 @@
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"github.com/meowmeowcode/hohin"
+	"strings"
+)
+
+// kinds maps SQLite extended error codes to kinds of hohin errors.
+var kinds = map[sqlite3.ErrNoExtended]error{
+	sqlite3.ErrConstraintUnique:     hohin.ErrUniqueViolation,
+	sqlite3.ErrConstraintPrimaryKey: hohin.ErrUniqueViolation,
+	sqlite3.ErrConstraintForeignKey: hohin.ErrForeignKeyViolation,
+	sqlite3.ErrConstraintCheck:      hohin.ErrCheckViolation,
+	sqlite3.ErrConstraintNotNull:    hohin.ErrNotNullViolation,
+}
+
+// classify wraps an SQLite error into a hohin.DBError if its kind is known.
+// Names of constraints and columns are parsed from an error message
+// like "UNIQUE constraint failed: users.Name".
+func classify(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	kind, ok := kinds[sqliteErr.ExtendedCode]
+	if sqliteErr.Code == sqlite3.ErrReadonly {
+		kind, ok = hohin.ErrReadOnly, true
+	}
+	if !ok {
+		return err
+	}
+	result := &hohin.DBError{Kind: kind, Err: err}
+	_, subject, found := strings.Cut(sqliteErr.Error(), "constraint failed: ")
+	if found {
+		switch kind {
+		case hohin.ErrCheckViolation:
+			result.Constraint = subject
+		case hohin.ErrUniqueViolation, hohin.ErrNotNullViolation:
+			column, _, _ := strings.Cut(subject, ",")
+			if _, name, ok := strings.Cut(column, "."); ok {
+				column = name
+			}
+			result.Column = column
+		}
+	}
+	return result
+}
+
+// queryError returns an error of a failed query.
+func queryError(query string, err error) error {
+	return fmt.Errorf("cannot execute query `%s`: %w", query, classify(err))
+}
@@ -0,0 +1,72 @@
+package sqlite3
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"regexp"
+	"sync"
+)
+
+// DriverName is the name of a database/sql driver for SQLite
+// that supports filters created with hohin.Matches and hohin.IMatches.
+// SQLite doesn't implement the REGEXP operator by itself,
+// so these filters fail on databases opened with the "sqlite3" driver.
+const DriverName = "sqlite3_hohin"
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", matchPattern, true)
+		},
+	})
+}
+
+// maxPatterns limits the number of compiled patterns kept in memory.
+const maxPatterns = 100
+
+var (
+	patternsMutex sync.Mutex
+	patterns      = map[string]*regexp.Regexp{}
+)
+
+// matchPattern implements the REGEXP operator:
+// "value REGEXP pattern" calls regexp(pattern, value).
+// It returns NULL if the value is NULL.
+func matchPattern(pattern string, value any) (any, error) {
+	var s string
+	switch v := value.(type) {
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		s = string(v)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(s), nil
+}
+
+// compilePattern compiles a pattern or returns it from the cache.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMutex.Lock()
+	defer patternsMutex.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) >= maxPatterns {
+		patterns = map[string]*regexp.Regexp{}
+	}
+	patterns[pattern] = re
+	return re, nil
+}
@@ -1,21 +1,51 @@
 package sqlite3
 
 import (
+	"database/sql/driver"
 	"github.com/meowmeowcode/hohin/sqldb"
+	"reflect"
 	"time"
 )
 
 type sqlite3Dialect struct{}
 
 func (d sqlite3Dialect) ProcessParam(p any, _ int) (string, any) {
+	if _, ok := p.(driver.Valuer); !ok {
+		p = sqldb.Deref(p)
+	}
+	switch p.(type) {
+	case driver.Valuer, time.Time:
+		return "?", textValuer{p}
+	}
+	return "?", p
+}
+
+// textValuer wraps a parameter that is either a time or a driver.Valuer
+// and converts times to text when a query is executed,
+// so that conversion errors are returned by the query.
+type textValuer struct {
+	value any
+}
+
+func (v textValuer) Value() (driver.Value, error) {
+	p := v.value
+	if valuer, ok := p.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(p); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil, nil
+		}
+		var err error
+		if p, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+	}
 	if param, ok := p.(time.Time); ok {
 		text, err := param.MarshalText()
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		return "?", string(text)
+		return string(text), nil
 	}
-	return "?", p
+	return p, nil
 }
 
 var dialect sqlite3Dialect
@@ -1,16 +1,60 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/meowmeowcode/hohin"
+	"github.com/meowmeowcode/hohin/sqldb"
 	"github.com/shopspring/decimal"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
 
+// Tags is a list of strings stored in a column with a JSON array.
+type Tags []string
+
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(t))
+	return string(data), err
+}
+
+func (t *Tags) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", src)
+	}
+}
+
+// Secret is a string stored with a prefix that cannot be empty.
+type Secret string
+
+var errEmptySecret = errors.New("secret is empty")
+
+func (s *Secret) Value() (driver.Value, error) {
+	if *s == "" {
+		return nil, errEmptySecret
+	}
+	return "secret:" + string(*s), nil
+}
+
 type User struct {
 	Id           uuid.UUID
 	Name         string
@@ -105,7 +149,7 @@ func addEve(db hohin.SimpleDB, repo hohin.SimpleRepo[User]) User {
 }
 
 func TestRepo(t *testing.T) {
-	pool, err := sql.Open("sqlite3", ":memory:")
+	pool, err := sql.Open(DriverName, ":memory:")
 	if err != nil {
 		panic(err)
 	}
@@ -185,6 +229,59 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestUpsert", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		bob.Name = "Robert"
+		if err := repo.Upsert(db, bob, "Id"); err != nil {
+			t.Fatal(err)
+		}
+		eve := User{
+			Id:           uuid.New(),
+			Name:         "Eve",
+			RegisteredAt: time.Date(2009, time.October, 10, 23, 0, 0, 0, time.UTC),
+		}
+		if err := repo.Upsert(db, eve, "Id"); err != nil {
+			t.Fatal(err)
+		}
+		users, err := repo.GetMany(db, hohin.Query{}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, eve, bob}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+		if err := repo.Upsert(db, bob); err == nil {
+			t.Fatal("upsert without conflict fields didn't fail")
+		}
+	})
+
+	t.Run("TestUpsertMany", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		alice.Age = 24
+		bob.Age = 28
+		eve := User{
+			Id:           uuid.New(),
+			Name:         "Eve",
+			RegisteredAt: time.Date(2009, time.October, 10, 23, 0, 0, 0, time.UTC),
+		}
+		if err := repo.UpsertMany(db, []User{alice, bob, eve}, "Id"); err != nil {
+			t.Fatal(err)
+		}
+		users, err := repo.GetMany(db, hohin.Query{}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+	})
+
 	t.Run("TestGet", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -209,6 +306,27 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestGetOne", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		addBob(db, repo)
+		u, err := repo.GetOne(db, hohin.Eq("Name", "Alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.Equal(&alice) {
+			t.Fatalf("%v != %v", alice, u)
+		}
+		_, err = repo.GetOne(db, hohin.Eq("Name", "Eve"))
+		if err != hohin.NotFound {
+			t.Fatalf("%v != %v", err, hohin.NotFound)
+		}
+		_, err = repo.GetOne(db, hohin.Or(hohin.Eq("Name", "Alice"), hohin.Eq("Name", "Bob")))
+		if err != hohin.ErrMultipleFound {
+			t.Fatalf("%v != %v", err, hohin.ErrMultipleFound)
+		}
+	})
+
 	t.Run("TestGetForUpdate", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -233,6 +351,26 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestLocks", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		u, err := repo.GetLocked(db, hohin.Eq("Id", alice.Id), hohin.Lock{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.Equal(&alice) {
+			t.Fatalf("%v != %v", alice, u)
+		}
+		_, err = repo.GetLocked(db, hohin.Eq("Id", alice.Id), hohin.Lock{Strength: hohin.ForUpdate})
+		if !errors.Is(err, hohin.ErrUnsupported) {
+			t.Fatalf("%v is not %v", err, hohin.ErrUnsupported)
+		}
+		_, err = repo.GetMany(db, hohin.Query{}.SkipLocked())
+		if !errors.Is(err, hohin.ErrUnsupported) {
+			t.Fatalf("%v is not %v", err, hohin.ErrUnsupported)
+		}
+	})
+
 	t.Run("TestExists", func(t *testing.T) {
 		cleanDB()
 		addAlice(db, repo)
@@ -288,6 +426,93 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestUpdateFields", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+		count, err := repo.UpdateFields(
+			db,
+			hohin.Contains("Name", "e"),
+			hohin.Set{hohin.Incr("Age", 2), hohin.Assign("Active", false)},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		alice.Age += 2
+		alice.Active = false
+		eve.Age += 2
+		users, err := repo.GetMany(db, hohin.Query{}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+		_, err = repo.UpdateFields(db, hohin.Eq("Name", "Bob"), hohin.Set{hohin.Assign("Test", 1)})
+		if err == nil {
+			t.Fatal("unknown field is accepted")
+		}
+	})
+
+	t.Run("TestUpdateCount", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		bob := addBob(db, repo)
+		bob.Name = "Robert"
+		count, err := repo.UpdateCount(db, hohin.Eq("Id", bob.Id), bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+		count, err = repo.UpdateCount(db, hohin.Eq("Name", "Eve"), bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%v != 0", count)
+		}
+	})
+
+	t.Run("TestStrictUpdate", func(t *testing.T) {
+		cleanDB()
+		strictRepo := NewRepo(Conf[User]{Table: "users", StrictUpdate: true}).Simple()
+		bob := addBob(db, repo)
+		if err := strictRepo.Update(db, hohin.Eq("Name", "Eve"), bob); err != hohin.NotFound {
+			t.Fatalf("%v != %v", err, hohin.NotFound)
+		}
+		if err := strictRepo.Update(db, hohin.Eq("Id", bob.Id), bob); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("TestDeleteCount", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		addBob(db, repo)
+		addEve(db, repo)
+		count, err := repo.DeleteCount(db, hohin.Contains("Name", "e"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		count, err = repo.CountAll(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+	})
+
 	t.Run("TestDelete", func(t *testing.T) {
 		cleanDB()
 		addAlice(db, repo)
@@ -321,6 +546,72 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestAggregates", func(t *testing.T) {
+		cleanDB()
+		addAlice(db, repo)
+		addBob(db, repo)
+		addEve(db, repo)
+
+		sum, err := repo.Sum(db, "Age", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.Equal(decimal.NewFromInt(86)) {
+			t.Fatalf("%v != 86", sum)
+		}
+
+		avg, err := repo.Avg(db, "Age", hohin.In("Name", []any{"Alice", "Bob"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !avg.Equal(decimal.NewFromInt(25)) {
+			t.Fatalf("%v != 25", avg)
+		}
+
+		min, err := repo.Min(db, "Money", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !min.Equal(decimal.RequireFromString("120.50")) {
+			t.Fatalf("%v != 120.50", min)
+		}
+
+		max, err := repo.Max(db, "Money", hohin.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !max.Equal(decimal.RequireFromString("168.31")) {
+			t.Fatalf("%v != 168.31", max)
+		}
+
+		sum, err = repo.Sum(db, "Age", hohin.Eq("Name", "Nobody"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.IsZero() {
+			t.Fatalf("%v != 0", sum)
+		}
+
+		groups, err := repo.Aggregate(db, hohin.Aggregation{Field: "Age", GroupBy: []string{"Active"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 2 {
+			t.Fatalf("%d != 2", len(groups))
+		}
+		if groups[0].Key[0] != false || groups[0].Count != 1 || !groups[0].Sum.Equal(decimal.NewFromInt(36)) {
+			t.Fatalf("unexpected group %v", groups[0])
+		}
+		if groups[1].Key[0] != true ||
+			groups[1].Count != 2 ||
+			!groups[1].Sum.Equal(decimal.NewFromInt(50)) ||
+			!groups[1].Avg.Equal(decimal.NewFromInt(25)) ||
+			!groups[1].Min.Equal(decimal.NewFromInt(23)) ||
+			!groups[1].Max.Equal(decimal.NewFromInt(27)) {
+			t.Fatalf("unexpected group %v", groups[1])
+		}
+	})
+
 	t.Run("TestLimit", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -376,6 +667,146 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestEach", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		users := []User{}
+		err := repo.Each(db, hohin.Query{}.OrderBy(hohin.Asc("Name")), func(u User) error {
+			users = append(users, u)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+
+		stop := errors.New("stop")
+		users = []User{}
+		err = repo.Each(db, hohin.Query{}.OrderBy(hohin.Asc("Name")), func(u User) error {
+			users = append(users, u)
+			if len(users) == 2 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) {
+			t.Fatalf("%v is not %v", err, stop)
+		}
+		expectedUsers = []User{alice, bob}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+	})
+
+	t.Run("TestGetPage", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		page, err := repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{alice, bob}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next == "" || page.Prev != "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		page, err = repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")).After(page.Next))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers = []User{eve}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next != "" || page.Prev == "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		page, err = repo.GetPage(db, hohin.Query{Limit: 2}.OrderBy(hohin.Asc("Name")).Before(page.Prev))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers = []User{alice, bob}
+		if !usersEqual(page.Items, expectedUsers) {
+			t.Fatalf("%v != %v", page.Items, expectedUsers)
+		}
+		if page.Next == "" || page.Prev != "" {
+			t.Fatalf("unexpected cursors %q and %q", page.Next, page.Prev)
+		}
+
+		users := []User{}
+		q := hohin.Query{Limit: 1}.OrderBy(hohin.Desc("Active"), hohin.Asc("Name"))
+		for {
+			page, err := repo.GetPage(db, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			users = append(users, page.Items...)
+			if page.Next == "" {
+				break
+			}
+			q = q.After(page.Next)
+		}
+		expectedUsers = []User{alice, bob, eve}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+	})
+
+	t.Run("TestProjection", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		eve := addEve(db, repo)
+
+		q := hohin.Query{}.OrderBy(hohin.Asc("Name"))
+		users, err := repo.GetMany(db, q.Select("Id", "Name"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedUsers := []User{
+			{Id: alice.Id, Name: alice.Name},
+			{Id: bob.Id, Name: bob.Name},
+			{Id: eve.Id, Name: eve.Name},
+		}
+		if !usersEqual(users, expectedUsers) {
+			t.Fatalf("%v != %v", users, expectedUsers)
+		}
+
+		type UserName struct {
+			Name string
+		}
+		names, err := hohin.SimpleProject[User, UserName](db, repo, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedNames := []UserName{{"Alice"}, {"Bob"}, {"Eve"}}
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Fatalf("%v != %v", names, expectedNames)
+		}
+
+		ages, err := hohin.SimplePluck[User, int](db, repo, "Age", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedAges := []int{23, 27, 36}
+		if !reflect.DeepEqual(ages, expectedAges) {
+			t.Fatalf("%v != %v", ages, expectedAges)
+		}
+	})
+
 	t.Run("TestFilters", func(t *testing.T) {
 		cleanDB()
 		alice := addAlice(db, repo)
@@ -414,7 +845,19 @@ func TestRepo(t *testing.T) {
 				filter: hohin.In("Age", []any{alice.Age, eve.Age}),
 				result: []User{alice, eve},
 			},
-			// float64 operations:
+			{
+				filter: hohin.In("Age", []any{}),
+				result: []User{},
+			},
+			{
+				filter: hohin.NotIn("Age", []any{alice.Age, eve.Age}),
+				result: []User{bob},
+			},
+			{
+				filter: hohin.Between("Age", alice.Age, bob.Age),
+				result: []User{alice, bob},
+			},
+			// float64 operations:
 			{
 				filter: hohin.Eq("Weight", bob.Weight),
 				result: []User{bob},
@@ -427,6 +870,10 @@ func TestRepo(t *testing.T) {
 				filter: hohin.Lt("Weight", bob.Weight),
 				result: []User{alice},
 			},
+			{
+				filter: hohin.Between("Weight", alice.Weight, bob.Weight),
+				result: []User{alice, bob},
+			},
 			{
 				filter: hohin.Gt("Weight", bob.Weight),
 				result: []User{eve},
@@ -506,6 +953,18 @@ func TestRepo(t *testing.T) {
 				filter: hohin.In("Name", []any{"Alice", "Bob"}),
 				result: []User{alice, bob},
 			},
+			{
+				filter: hohin.In("Name", []string{"Alice", "Eve"}),
+				result: []User{alice, eve},
+			},
+			{
+				filter: hohin.NotIn("Name", []string{"Alice", "Bob"}),
+				result: []User{eve},
+			},
+			{
+				filter: hohin.NotIn("Name", []string{}),
+				result: []User{alice, bob, eve},
+			},
 			{
 				filter: hohin.HasPrefix("Name", "A"),
 				result: []User{alice},
@@ -542,6 +1001,58 @@ func TestRepo(t *testing.T) {
 				filter: hohin.IContains("Name", "O"),
 				result: []User{bob},
 			},
+			{
+				filter: hohin.Contains("Name", "%"),
+				result: []User{},
+			},
+			{
+				filter: hohin.IHasPrefix("Name", "_"),
+				result: []User{},
+			},
+			{
+				filter: hohin.HasSuffix("Name", "\\"),
+				result: []User{},
+			},
+			{
+				filter: hohin.Like("Name", "A%"),
+				result: []User{alice},
+			},
+			{
+				filter: hohin.Like("Name", "_ve"),
+				result: []User{eve},
+			},
+			{
+				filter: hohin.Like("Name", "b%"),
+				result: []User{},
+			},
+			{
+				filter: hohin.ILike("Name", "b%"),
+				result: []User{bob},
+			},
+			{
+				filter: hohin.Like("Name", hohin.EscapeLike("_ve")),
+				result: []User{},
+			},
+			{
+				filter: hohin.Matches("Name", "^[AE].*e$"),
+				result: []User{alice, eve},
+			},
+			{
+				filter: hohin.Matches("Name", "^a"),
+				result: []User{},
+			},
+			{
+				filter: hohin.IMatches("Name", "^a"),
+				result: []User{alice},
+			},
+			{
+				filter: hohin.Matches("Name", "o|v"),
+				result: []User{bob, eve},
+			},
+			{
+				filter: hohin.IMatches("Name", "[[:upper:]]O"),
+				result: []User{bob},
+			},
 			// time.Time operations:
 			{
 				filter: hohin.Eq("RegisteredAt", eve.RegisteredAt),
@@ -576,6 +1087,10 @@ func TestRepo(t *testing.T) {
 				filter: hohin.Ne("Id", eve.Id),
 				result: []User{alice, bob},
 			},
+			{
+				filter: hohin.In("Id", []uuid.UUID{alice.Id, eve.Id}),
+				result: []User{alice, eve},
+			},
 			// Not, And, Or:
 			{
 				filter: hohin.Not(hohin.Contains("Name", "e")),
@@ -599,6 +1114,10 @@ func TestRepo(t *testing.T) {
 				t.Errorf("filter: %v; expected result: %v; actual result: %v", cs.filter, cs.result, result)
 			}
 		}
+		_, err := repo.GetMany(db, hohin.Query{Filter: hohin.Matches("Name", `\d`)})
+		if err == nil {
+			t.Fatal("Invalid pattern was accepted")
+		}
 	})
 
 	t.Run("TestGetFirst", func(t *testing.T) {
@@ -731,6 +1250,353 @@ func TestRepo(t *testing.T) {
 		}
 	})
 
+	t.Run("TestNestedTransaction", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		bob := addBob(db, repo)
+		addEve(db, repo)
+		err := db.Transaction(func(db hohin.SimpleDB) error {
+			if err := repo.Delete(db, hohin.Eq("Id", alice.Id)); err != nil {
+				return err
+			}
+			err := db.Transaction(func(db hohin.SimpleDB) error {
+				repo.Delete(db, hohin.Eq("Id", bob.Id))
+				return errors.New("fail")
+			})
+			if err == nil {
+				t.Fatal("Nested transaction didn't fail")
+			}
+			exists, err := repo.Exists(db, hohin.Eq("Id", bob.Id))
+			if err != nil {
+				return err
+			}
+			if !exists {
+				t.Fatal("Nested transaction wasn't rolled back")
+			}
+			return db.Transaction(func(db hohin.SimpleDB) error {
+				return db.Transaction(func(db hohin.SimpleDB) error {
+					return repo.Delete(db, hohin.Eq("Id", bob.Id))
+				})
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := repo.CountAll(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+	})
+
+	t.Run("TestTxRetry", func(t *testing.T) {
+		cleanDB()
+		policy := hohin.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+		attempts := 0
+		err := db.TxRetry(hohin.Serializable, policy, func(db hohin.SimpleDB) error {
+			attempts++
+			addAlice(db, repo)
+			if attempts < 2 {
+				return sqlite3.Error{Code: sqlite3.ErrBusy}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Fatalf("%v != 2", attempts)
+		}
+		count, err := repo.CountAll(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+
+		attempts = 0
+		err = db.TxRetry(hohin.Serializable, policy, func(db hohin.SimpleDB) error {
+			attempts++
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		})
+		if err == nil {
+			t.Fatal("Transaction didn't fail")
+		}
+		if attempts != 3 {
+			t.Fatalf("%v != 3", attempts)
+		}
+
+		attempts = 0
+		err = db.TxRetry(hohin.Serializable, policy, func(db hohin.SimpleDB) error {
+			attempts++
+			return errors.New("fail")
+		})
+		if err == nil {
+			t.Fatal("Transaction didn't fail")
+		}
+		if attempts != 1 {
+			t.Fatalf("%v != 1", attempts)
+		}
+	})
+
+	t.Run("TestTxWithOptions", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		err := db.TxWithOptions(hohin.TxOptions{ReadOnly: true}, func(db hohin.SimpleDB) error {
+			exists, err := repo.Exists(db, hohin.Eq("Id", alice.Id))
+			if err != nil {
+				return err
+			}
+			if !exists {
+				t.Fatal("Alice wasn't found in a read-only transaction")
+			}
+			return repo.Delete(db, hohin.Eq("Id", alice.Id))
+		})
+		if !errors.Is(err, hohin.ErrReadOnly) {
+			t.Fatalf("%v is not %v", err, hohin.ErrReadOnly)
+		}
+		opts := hohin.TxOptions{Isolation: hohin.Serializable, Timeout: time.Minute, LockTimeout: time.Second}
+		err = db.TxWithOptions(opts, func(db hohin.SimpleDB) error {
+			addBob(db, repo)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := repo.CountAll(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+
+		var busyTimeout int64
+		opts = hohin.TxOptions{LockTimeout: time.Microsecond}
+		err = NewDB(pool).TxWithOptions(context.Background(), opts, func(ctx context.Context, db hohin.DB) error {
+			return db.(*DB).executor.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if busyTimeout != 1 {
+			t.Fatalf("%v != 1", busyTimeout)
+		}
+	})
+
+	t.Run("TestTxHooks", func(t *testing.T) {
+		var events []string
+		record := func(event string) func() {
+			return func() {
+				events = append(events, event)
+			}
+		}
+		err := db.Transaction(func(db hohin.SimpleDB) error {
+			db.AfterCommit(record("commit"))
+			db.AfterRollback(record("rollback"))
+			err := db.Transaction(func(db hohin.SimpleDB) error {
+				db.AfterCommit(record("failed nested commit"))
+				db.AfterRollback(record("failed nested rollback"))
+				return errors.New("fail")
+			})
+			if err == nil {
+				t.Fatal("Nested transaction didn't fail")
+			}
+			return db.Transaction(func(db hohin.SimpleDB) error {
+				db.AfterCommit(record("nested commit"))
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"failed nested rollback", "commit", "nested commit"}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("%v != %v", events, expected)
+		}
+
+		events = nil
+		err = db.Transaction(func(db hohin.SimpleDB) error {
+			db.AfterCommit(record("commit"))
+			db.AfterRollback(record("rollback"))
+			return errors.New("fail")
+		})
+		if err == nil {
+			t.Fatal("Transaction didn't fail")
+		}
+		db.AfterCommit(record("no transaction"))
+		expected = []string{"rollback", "no transaction"}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("%v != %v", events, expected)
+		}
+	})
+
+	t.Run("TestAmbientTx", func(t *testing.T) {
+		_, err = pool.Exec(`DROP TABLE IF EXISTS events`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE events (Id uuid PRIMARY KEY, Name text)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Event struct {
+			Id   uuid.UUID
+			Name string
+		}
+		ambientDB := NewDB(pool).UseAmbientTx()
+		eventsRepo := NewRepo(Conf[Event]{Table: "events"})
+		ctx := context.Background()
+		event := Event{Id: uuid.New(), Name: "created"}
+		if err := eventsRepo.Add(ctx, ambientDB, event); err != nil {
+			t.Fatal(err)
+		}
+
+		var hooks []string
+		err := ambientDB.Transaction(ctx, func(ctx context.Context, _ hohin.DB) error {
+			hohin.AfterCommit(ctx, ambientDB, func() { hooks = append(hooks, "commit") })
+			hohin.AfterRollback(ctx, ambientDB, func() { hooks = append(hooks, "rollback") })
+			if len(hooks) != 0 {
+				t.Fatal("Hook was executed before the transaction finished")
+			}
+			if err := eventsRepo.Delete(ctx, ambientDB, hohin.Eq("Id", event.Id)); err != nil {
+				return err
+			}
+			found := make(chan bool)
+			go func() {
+				exists, err := eventsRepo.Exists(ctx, ambientDB, hohin.Eq("Id", event.Id))
+				found <- exists || err != nil
+			}()
+			if <-found {
+				t.Fatal("Goroutine didn't join the transaction")
+			}
+			return errors.New("fail")
+		})
+		if err == nil {
+			t.Fatal("Transaction didn't fail")
+		}
+		if !reflect.DeepEqual(hooks, []string{"rollback"}) {
+			t.Fatalf("%v != [rollback]", hooks)
+		}
+		exists, err := eventsRepo.Exists(ctx, ambientDB, hohin.Eq("Id", event.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("Transaction wasn't rolled back")
+		}
+
+		err = ambientDB.Transaction(ctx, func(ctx context.Context, _ hohin.DB) error {
+			return ambientDB.Transaction(ctx, func(ctx context.Context, _ hohin.DB) error {
+				return eventsRepo.Delete(ctx, ambientDB, hohin.Eq("Id", event.Id))
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := eventsRepo.CountAll(ctx, ambientDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%v != 0", count)
+		}
+
+		err = ambientDB.Transaction(ctx, func(ctx context.Context, _ hohin.DB) error {
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := eventsRepo.Add(ctx, ambientDB, Event{Id: uuid.New(), Name: "concurrent"}); err != nil {
+						errs <- err
+						return
+					}
+					if _, err := eventsRepo.GetMany(ctx, ambientDB, hohin.Query{}); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			return <-errs
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err = eventsRepo.Count(ctx, ambientDB, hohin.Eq("Name", "concurrent"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 10 {
+			t.Fatalf("%v != 10", count)
+		}
+	})
+
+	t.Run("TestTxPanic", func(t *testing.T) {
+		cleanDB()
+		bob := addBob(db, repo)
+		func() {
+			defer func() {
+				if r := recover(); r != "boom" {
+					t.Fatalf("%v != boom", r)
+				}
+			}()
+			db.Transaction(func(db hohin.SimpleDB) error {
+				repo.Delete(db, hohin.Eq("Id", bob.Id))
+				panic("boom")
+			})
+		}()
+		exists, err := repo.Exists(db, hohin.Eq("Id", bob.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("Transaction wasn't rolled back")
+		}
+
+		err = db.TxWithOptions(hohin.TxOptions{RecoverPanic: true}, func(db hohin.SimpleDB) error {
+			repo.Delete(db, hohin.Eq("Id", bob.Id))
+			panic("boom")
+		})
+		var panicErr *hohin.PanicError
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("%v is not a PanicError", err)
+		}
+		if panicErr.Value != "boom" {
+			t.Fatalf("%v != boom", panicErr.Value)
+		}
+		exists, err = repo.Exists(db, hohin.Eq("Id", bob.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("Transaction wasn't rolled back")
+		}
+
+		err = db.Transaction(func(db hohin.SimpleDB) error {
+			err := db.TxWithOptions(hohin.TxOptions{RecoverPanic: true}, func(db hohin.SimpleDB) error {
+				repo.Delete(db, hohin.Eq("Id", bob.Id))
+				panic("boom")
+			})
+			return fmt.Errorf("nested transaction failed: %w", err)
+		})
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("%v is not a PanicError", err)
+		}
+		exists, err = repo.Exists(db, hohin.Eq("Id", bob.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("Transaction wasn't rolled back")
+		}
+	})
+
 	t.Run("NullTest", func(t *testing.T) {
 		_, err = pool.Exec(`CREATE TABLE options (Value text)`)
 		if err != nil {
@@ -759,5 +1625,466 @@ func TestRepo(t *testing.T) {
 		if count != 2 {
 			t.Fatalf("%v != 2", count)
 		}
+		count, err = optionsRepo.UpdateFields(db, hohin.Not(hohin.IsNull("Value")), hohin.Set{hohin.SetNull("Value")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%v != 2", count)
+		}
+		count, err = optionsRepo.Count(db, hohin.IsNull("Value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatalf("%v != 3", count)
+		}
+	})
+
+	t.Run("TestStructTags", func(t *testing.T) {
+		_, err = pool.Exec(`
+			CREATE TABLE products (
+				id uuid PRIMARY KEY,
+				title text NOT NULL,
+				serial bigint NOT NULL DEFAULT 7
+			)
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Product struct {
+			Id      uuid.UUID `hohin:"id,pk"`
+			Title   string    `hohin:"title"`
+			Serial  int       `hohin:"serial,readonly"`
+			Comment string    `hohin:"-"`
+		}
+		productsRepo := NewRepo(Conf[Product]{Table: "products"}).Simple()
+
+		product := Product{Id: uuid.New(), Title: "Pen", Serial: 100, Comment: "blue"}
+		if err := productsRepo.Add(db, product); err != nil {
+			t.Fatal(err)
+		}
+		p, err := productsRepo.Get(db, hohin.Eq("Title", "Pen"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Product{Id: product.Id, Title: "Pen", Serial: 7}
+		if p != expected {
+			t.Fatalf("%v != %v", p, expected)
+		}
+
+		p.Title = "Pencil"
+		p.Serial = 8
+		if err := productsRepo.Update(db, hohin.Eq("Id", p.Id), p); err != nil {
+			t.Fatal(err)
+		}
+		p, err = productsRepo.Get(db, hohin.Eq("Id", p.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = Product{Id: product.Id, Title: "Pencil", Serial: 7}
+		if p != expected {
+			t.Fatalf("%v != %v", p, expected)
+		}
+	})
+
+	t.Run("TestNestedStructs", func(t *testing.T) {
+		_, err = pool.Exec(`
+			CREATE TABLE customers (
+				Id uuid PRIMARY KEY,
+				Name text NOT NULL,
+				CreatedAt timestamp NOT NULL,
+				address_city text NOT NULL,
+				address_zip text NOT NULL
+			)
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Timestamps struct {
+			CreatedAt time.Time
+		}
+		type Address struct {
+			City string `hohin:"city"`
+			Zip  string `hohin:"zip"`
+		}
+		type Customer struct {
+			Id   uuid.UUID
+			Name string
+			Timestamps
+			Address Address `hohin:"address"`
+		}
+		customersRepo := NewRepo(Conf[Customer]{Table: "customers"}).Simple()
+
+		customer := Customer{
+			Id:         uuid.New(),
+			Name:       "Alice",
+			Timestamps: Timestamps{CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)},
+			Address:    Address{City: "Paris", Zip: "75001"},
+		}
+		if err := customersRepo.Add(db, customer); err != nil {
+			t.Fatal(err)
+		}
+		c, err := customersRepo.Get(db, hohin.Eq("Address.City", "Paris"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != customer {
+			t.Fatalf("%v != %v", c, customer)
+		}
+		count, err := customersRepo.Count(db, hohin.Eq("Address.City", "London"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%v != 0", count)
+		}
+	})
+
+	t.Run("TestNullableFields", func(t *testing.T) {
+		_, err = pool.Exec(`
+			CREATE TABLE notes (
+				Id uuid PRIMARY KEY,
+				Text text,
+				Rating bigint,
+				ReadAt timestamp
+			)
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Note struct {
+			Id     uuid.UUID
+			Text   *string
+			Rating sql.NullInt64
+			ReadAt *time.Time
+		}
+		notesRepo := NewRepo(Conf[Note]{Table: "notes"}).Simple()
+
+		text := "hello"
+		readAt := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+		note := Note{Id: uuid.New(), Text: &text, Rating: sql.NullInt64{Int64: 5, Valid: true}, ReadAt: &readAt}
+		if err := notesRepo.Add(db, note); err != nil {
+			t.Fatal(err)
+		}
+		if err := notesRepo.Add(db, Note{Id: uuid.New()}); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := notesRepo.Get(db, hohin.Eq("Id", note.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *n.Text != text || n.Rating != note.Rating || !n.ReadAt.Equal(readAt) {
+			t.Fatalf("%v != %v", n, note)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.IsNull("Text"), 1},
+			{hohin.IsNull("Rating"), 1},
+			{hohin.IsNotNull("Text"), 1},
+			{hohin.In("Rating", []any{5, nil}), 1},
+			{hohin.NotIn("Rating", []int64{1, 2}), 1},
+			{hohin.Between("Rating", 1, 5), 1},
+			{hohin.Eq("Text", text), 1},
+			{hohin.Eq("Text", &text), 1},
+			{hohin.Ne("Text", text), 0},
+			{hohin.Not(hohin.Eq("Text", text)), 0},
+			{hohin.Gt("Rating", 3), 1},
+			{hohin.Lt("ReadAt", readAt.Add(time.Hour)), 1},
+			{hohin.Or(hohin.Eq("Text", "bye"), hohin.IsNull("ReadAt")), 1},
+		}
+		for _, c := range cases {
+			count, err := notesRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
+	})
+
+	t.Run("TestValuerParams", func(t *testing.T) {
+		_, err = pool.Exec(`CREATE TABLE tokens (Id uuid PRIMARY KEY, Secret text)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Token struct {
+			Id     uuid.UUID
+			Secret *Secret
+		}
+		tokensRepo := NewRepo(Conf[Token]{Table: "tokens"}).Simple()
+
+		secret := Secret("abc")
+		token := Token{Id: uuid.New(), Secret: &secret}
+		if err := tokensRepo.Add(db, token); err != nil {
+			t.Fatal(err)
+		}
+		var stored string
+		if err := pool.QueryRow(`SELECT Secret FROM tokens`).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != "secret:abc" {
+			t.Fatalf("%v != secret:abc", stored)
+		}
+
+		empty := Secret("")
+		err = tokensRepo.Add(db, Token{Id: uuid.New(), Secret: &empty})
+		if !errors.Is(err, errEmptySecret) {
+			t.Fatalf("%v is not an empty secret error", err)
+		}
+		if err := tokensRepo.Add(db, Token{Id: uuid.New()}); err != nil {
+			t.Fatal(err)
+		}
+		count, err := tokensRepo.Count(db, hohin.IsNull("Secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("%v != 1", count)
+		}
+	})
+
+	t.Run("TestErrors", func(t *testing.T) {
+		cleanDB()
+		alice := addAlice(db, repo)
+		err := repo.Add(db, alice)
+		if !errors.Is(err, hohin.ErrUniqueViolation) {
+			t.Fatalf("%v is not a unique violation", err)
+		}
+		var dbErr *hohin.DBError
+		if !errors.As(err, &dbErr) {
+			t.Fatalf("%v is not a DBError", err)
+		}
+		if errors.Is(err, hohin.ErrNotNullViolation) {
+			t.Fatalf("%v is a not null violation", err)
+		}
+
+		copyingRepo := NewRepo(Conf[User]{
+			Table: "users",
+			Query: "INSERT INTO users SELECT * FROM users",
+			Load: func(row Scanner) (User, error) {
+				var u User
+				return u, row.Scan(&u.Id)
+			},
+		}).Simple()
+		_, err = copyingRepo.Get(db, hohin.Eq("Id", alice.Id))
+		if !errors.Is(err, hohin.ErrUniqueViolation) {
+			t.Fatalf("%v is not a unique violation", err)
+		}
+	})
+
+	t.Run("TestOptimisticLocking", func(t *testing.T) {
+		_, err := pool.Exec(`DROP TABLE IF EXISTS documents`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE documents (Id uuid PRIMARY KEY, Title text, Version bigint)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`DROP TABLE IF EXISTS revisions`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE revisions (Title text)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Document struct {
+			Id      uuid.UUID
+			Title   string
+			Version int `hohin:",version"`
+		}
+		docsRepo := NewRepo(Conf[Document]{
+			Table: "documents",
+			AfterUpdate: func(d Document) []*sqldb.SQL {
+				return []*sqldb.SQL{NewSQL("INSERT INTO revisions (Title) VALUES (").Param(d.Title).Add(")")}
+			},
+		}).Simple()
+		doc := Document{Id: uuid.New(), Title: "Draft", Version: 1}
+		if err := docsRepo.Add(db, doc); err != nil {
+			t.Fatal(err)
+		}
+
+		doc.Title = "Final"
+		if err := docsRepo.Update(db, hohin.Eq("Id", doc.Id), doc); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := docsRepo.Get(db, hohin.Eq("Id", doc.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Version != 2 || stored.Title != "Final" {
+			t.Fatalf("%v is not updated", stored)
+		}
+
+		doc.Title = "Stale"
+		err = docsRepo.Update(db, hohin.Eq("Id", doc.Id), doc)
+		if err != hohin.ErrStaleEntity {
+			t.Fatalf("%v != %v", err, hohin.ErrStaleEntity)
+		}
+		stored, err = docsRepo.Get(db, hohin.Eq("Id", doc.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Title != "Final" {
+			t.Fatalf("%v != Final", stored.Title)
+		}
+		var revisions int
+		if err := pool.QueryRow(`SELECT COUNT(*) FROM revisions`).Scan(&revisions); err != nil {
+			t.Fatal(err)
+		}
+		if revisions != 1 {
+			t.Fatalf("%v != 1", revisions)
+		}
+	})
+
+	t.Run("TestUpsertWithoutUpdates", func(t *testing.T) {
+		_, err := pool.Exec(`DROP TABLE IF EXISTS labels`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE labels (Name text PRIMARY KEY)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`DELETE FROM revisions`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Label struct {
+			Name string
+		}
+		labelsRepo := NewRepo(Conf[Label]{
+			Table: "labels",
+			AfterUpdate: func(l Label) []*sqldb.SQL {
+				return []*sqldb.SQL{NewSQL("INSERT INTO revisions (Title) VALUES (").Param(l.Name).Add(")")}
+			},
+		}).Simple()
+		if err := labelsRepo.Add(db, Label{Name: "draft"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := labelsRepo.UpsertMany(db, []Label{{Name: "draft"}, {Name: "final"}}, "Name"); err != nil {
+			t.Fatal(err)
+		}
+		var revisions int
+		if err := pool.QueryRow(`SELECT COUNT(*) FROM revisions`).Scan(&revisions); err != nil {
+			t.Fatal(err)
+		}
+		if revisions != 1 {
+			t.Fatalf("%v != 1", revisions)
+		}
+	})
+
+	t.Run("TestFieldRefs", func(t *testing.T) {
+		_, err := pool.Exec(`DROP TABLE IF EXISTS budgets`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE budgets (Id uuid PRIMARY KEY, Owner text, Manager text, Planned bigint, Spent bigint)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Budget struct {
+			Id      uuid.UUID
+			Owner   string
+			Manager string
+			Planned int
+			Spent   int
+		}
+		budgetsRepo := NewRepo(Conf[Budget]{Table: "budgets"}).Simple()
+		budgets := []Budget{
+			{Id: uuid.New(), Owner: "Alice", Manager: "alice", Planned: 100, Spent: 50},
+			{Id: uuid.New(), Owner: "Bob", Manager: "Eve", Planned: 100, Spent: 100},
+			{Id: uuid.New(), Owner: "Eve", Manager: "Eve", Planned: 100, Spent: 150},
+		}
+		if err := budgetsRepo.AddMany(db, budgets); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.Eq("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Ne("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Gt("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Gte("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Lt("Spent", hohin.FieldRef("Planned")), 1},
+			{hohin.Lte("Spent", hohin.FieldRef("Planned")), 2},
+			{hohin.Eq("Owner", hohin.FieldRef("Manager")), 1},
+			{hohin.IEq("Owner", hohin.FieldRef("Manager")), 2},
+			{hohin.INe("Owner", hohin.FieldRef("Manager")), 1},
+		}
+		for _, c := range cases {
+			count, err := budgetsRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
+
+		_, err = budgetsRepo.Count(db, hohin.Gt("Spent", hohin.FieldRef("Unknown")))
+		if err == nil {
+			t.Fatal("Unknown field was accepted")
+		}
+		_, err = budgetsRepo.Count(db, hohin.In("Owner", hohin.FieldRef("Manager")))
+		if err == nil {
+			t.Fatal("Unsupported operation was accepted")
+		}
+	})
+
+	t.Run("TestArrays", func(t *testing.T) {
+		_, err := pool.Exec(`DROP TABLE IF EXISTS articles`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Exec(`CREATE TABLE articles (Id uuid PRIMARY KEY, Tags text)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type Article struct {
+			Id   uuid.UUID
+			Tags Tags
+		}
+		articlesRepo := NewRepo(Conf[Article]{Table: "articles"}).Simple()
+		articles := []Article{
+			{Id: uuid.New(), Tags: Tags{"go", "sql"}},
+			{Id: uuid.New(), Tags: Tags{"go"}},
+			{Id: uuid.New()},
+		}
+		if err := articlesRepo.AddMany(db, articles); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			filter   hohin.Filter
+			expected uint64
+		}{
+			{hohin.HasElement("Tags", "go"), 2},
+			{hohin.HasElement("Tags", "sql"), 1},
+			{hohin.Not(hohin.HasElement("Tags", "sql")), 1},
+			{hohin.HasAny("Tags", []string{"sql", "rust"}), 1},
+			{hohin.HasAny("Tags", []string{}), 0},
+			{hohin.HasAll("Tags", []string{"go", "sql"}), 1},
+			{hohin.HasAll("Tags", []any{"go"}), 2},
+			{hohin.ArrayLen("Tags", 1), 1},
+		}
+		for _, c := range cases {
+			count, err := articlesRepo.Count(db, c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.expected {
+				t.Fatalf("%v: %v != %v", c.filter, count, c.expected)
+			}
+		}
 	})
 }